@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crawlArchive is a saved set of raw Cloud Controller responses, keyed by
+// request URI (ie "/v2/organizations?page=2"), so that a crawl can be replayed
+// later without any connection to CloudFoundry. Responses from other hosts,
+// such as UAA, are keyed by host and request URI (ie "uaa.example.com/Users").
+type crawlArchive struct {
+	// API url that the responses were fetched from
+	API string `json:"api"`
+
+	// CreatedAt is when the archive was first recorded
+	CreatedAt time.Time `json:"created_at"`
+
+	// Responses is the raw response body for every successful request
+	Responses map[string]json.RawMessage `json:"responses"`
+
+	mu sync.Mutex
+}
+
+func newCrawlArchive(api string) *crawlArchive {
+	return &crawlArchive{
+		API:       api,
+		CreatedAt: time.Now().UTC(),
+		Responses: make(map[string]json.RawMessage),
+	}
+}
+
+// loadCrawlArchive reads an archive previously written by save
+func loadCrawlArchive(path string) (*crawlArchive, error) {
+	f, err := openCompressed(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rv crawlArchive
+	err = json.NewDecoder(f).Decode(&rv)
+	if err != nil {
+		return nil, err
+	}
+	if rv.Responses == nil {
+		rv.Responses = make(map[string]json.RawMessage)
+	}
+	return &rv, nil
+}
+
+// save writes the archive to path as a single JSON document, compressed if
+// path ends in .gz
+func (a *crawlArchive) save(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return writeFileAtomic(path, privateFile, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(a)
+	})
+}
+
+// key returns the key for a request to u
+func (a *crawlArchive) key(u *url.URL) string {
+	api, err := url.Parse(a.API)
+	if err == nil && api.Host == u.Host {
+		return u.RequestURI()
+	}
+	return u.Host + u.RequestURI()
+}
+
+func (a *crawlArchive) add(r string, body []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Responses[r] = json.RawMessage(body)
+}
+
+// RoundTrip serves requests from the archive, so that the archive can be used
+// as the transport for a simpleClient. Anything not in the archive is a 404.
+func (a *crawlArchive) RoundTrip(req *http.Request) (*http.Response, error) {
+	a.mu.Lock()
+	body, ok := a.Responses[a.key(req.URL)]
+	a.mu.Unlock()
+
+	rv := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+	if !ok {
+		rv.Status = "404 Not Found"
+		rv.StatusCode = http.StatusNotFound
+		rv.Body = ioutil.NopCloser(strings.NewReader(""))
+	}
+	return rv, nil
+}
+
+// recordingTransport wraps another transport, and adds the body of every
+// successful response to an archive
+type recordingTransport struct {
+	archive *crawlArchive
+	base    http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.archive.add(t.archive.key(req.URL), body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// newArchiveClient returns a client that answers every request from a saved
+// archive instead of CloudFoundry
+func newArchiveClient(archive *crawlArchive, verbose bool) *simpleClient {
+	return &simpleClient{
+		API:     archive.API,
+		Verbose: verbose,
+		client:  &http.Client{Transport: archive},
+	}
+}
+
+// recordTo wraps the client's transport so that all responses are also saved to archive
+func (sc *simpleClient) recordTo(archive *crawlArchive) {
+	base := sc.client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	sc.client = &http.Client{
+		Transport: &recordingTransport{
+			archive: archive,
+			base:    base,
+		},
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cfConfig is the subset of the cf CLI's config.json that we need
+type cfConfig struct {
+	Target                string
+	AccessToken           string
+	RefreshToken          string
+	SSLDisabled           bool
+	UaaEndpoint           string
+	AuthorizationEndpoint string
+	UAAOAuthClient        string
+	UAAOAuthClientSecret  string
+}
+
+// cfConfigPath returns the location of the cf CLI config, which is
+// $CF_HOME/.cf/config.json, or in the user's home directory if CF_HOME is not set
+func cfConfigPath() (string, error) {
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(home, ".cf", "config.json"), nil
+}
+
+// loadCFConfig reads the cf CLI config from path
+func loadCFConfig(path string) (*cfConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rv cfConfig
+	err = json.NewDecoder(f).Decode(&rv)
+	if err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// connection returns a uaaConnection that starts with the access token
+// saved by "cf login", and uses the saved refresh token when it expires.
+// Refreshed tokens are not written back to the config.
+func (c *cfConfig) connection() *uaaConnection {
+	tokenEndpoint := c.UaaEndpoint
+	if tokenEndpoint == "" {
+		tokenEndpoint = c.AuthorizationEndpoint
+	}
+	clientID := c.UAAOAuthClient
+	if clientID == "" {
+		clientID = "cf"
+	}
+
+	rv := &uaaConnection{
+		API:           c.Target,
+		ClientID:      clientID,
+		ClientSecret:  c.UAAOAuthClientSecret,
+		RefreshToken:  c.RefreshToken,
+		TokenEndpoint: tokenEndpoint,
+		SSLDisabled:   c.SSLDisabled,
+		accessToken:   c.AccessToken,
+		expiry:        jwtExpiry(c.AccessToken),
+	}
+	if rv.RefreshToken == "" {
+		// nothing to refresh with, so use what we have
+		rv.Token = c.AccessToken
+	}
+	return rv
+}
+
+// fallback returns the connection saved by "cf login" to use in place of
+// conn, which has no API set. If conn has credentials of its own, they win,
+// and only the API, UAA and SSL settings are taken from the config.
+func (c *cfConfig) fallback(conn *uaaConnection) *uaaConnection {
+	saved := c.connection()
+	if conn.Token == "" && conn.ClientID == "" && conn.RefreshToken == "" {
+		return saved
+	}
+	conn.API = saved.API
+	conn.TokenEndpoint = saved.TokenEndpoint
+	conn.SSLDisabled = saved.SSLDisabled
+	return conn
+}
+
+// jwtExpiry returns the "exp" claim of a JWT access token, with or without a
+// "bearer " prefix, or the zero time if it can't be determined
+func jwtExpiry(token string) time.Time {
+	if i := strings.LastIndex(token, " "); i != -1 {
+		token = token[i+1:]
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// crawlCheckpoint records each org as it is completed, so that an interrupted
+// crawl can be resumed. The file is JSON lines, starting with a header naming
+// the API and the options the crawl depends on, followed by one checkpointOrg
+// per completed org. It is appended to as the crawl progresses, so that
+// nothing is lost if we are killed. All methods are safe to call on a nil
+// *crawlCheckpoint, which records nothing.
+type crawlCheckpoint struct {
+	path string
+	f    *os.File
+	orgs map[string]*checkpointOrg
+	mu   sync.Mutex
+}
+
+// checkpointHeader is the first line of a checkpoint file. Resuming with
+// different options would mix orgs crawled in different ways, so they must
+// match.
+type checkpointHeader struct {
+	API             string `json:"api"`
+	IncludeOrgUsers bool   `json:"include_org_users,omitempty"`
+	OrgSelector     string `json:"org_selector,omitempty"`
+	SpaceSelector   string `json:"space_selector,omitempty"`
+}
+
+// options returns the crawl options in the header, as they would be given on
+// the command line
+func (h *checkpointHeader) options() string {
+	return fmt.Sprintf("--org-users=%t --org-selector=%q --space-selector=%q", h.IncludeOrgUsers, h.OrgSelector, h.SpaceSelector)
+}
+
+// checkpointOrg is everything collected from one org
+type checkpointOrg struct {
+	GUID   string              `json:"guid"`
+	Items  []*userInfoLineItem `json:"items"`
+	Spaces []spaceRef          `json:"spaces"`
+}
+
+// openCheckpoint reads any orgs already completed from the checkpoint at
+// path, and opens it to record more. A new file is started with header if
+// there is none, and an existing one must have been started with the same header.
+func openCheckpoint(path string, header *checkpointHeader) (*crawlCheckpoint, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	cp := &crawlCheckpoint{
+		path: path,
+		f:    f,
+		orgs: make(map[string]*checkpointOrg),
+	}
+
+	// a crawl killed part way through writing may have left a partial last
+	// line, so keep only up to the last complete one
+	r := bufio.NewReader(f)
+	var good int64
+	for n := 0; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if n == 0 {
+			var existing checkpointHeader
+			err = json.Unmarshal(line, &existing)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s is not a checkpoint file: %s", path, err)
+			}
+			if existing.API != header.API {
+				f.Close()
+				return nil, fmt.Errorf("checkpoint %s is for %s, not %s", path, existing.API, header.API)
+			}
+			if existing != *header {
+				f.Close()
+				return nil, fmt.Errorf("checkpoint %s was started with %s, not %s", path, existing.options(), header.options())
+			}
+		} else {
+			var org checkpointOrg
+			err = json.Unmarshal(line, &org)
+			if err != nil {
+				break
+			}
+			cp.orgs[org.GUID] = &org
+		}
+		good += int64(len(line))
+	}
+
+	err = f.Truncate(good)
+	if err == nil {
+		_, err = f.Seek(good, io.SeekStart)
+	}
+	if err == nil && good == 0 {
+		err = cp.write(header)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// write appends v to the checkpoint as one line
+func (cp *crawlCheckpoint) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = cp.f.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	return cp.f.Sync()
+}
+
+// done returns the result for the org with guid, if an earlier crawl completed it
+func (cp *crawlCheckpoint) done(guid string) (*crawlResult, bool) {
+	if cp == nil {
+		return nil, false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	org, ok := cp.orgs[guid]
+	if !ok {
+		return nil, false
+	}
+	return &crawlResult{Items: org.Items, Spaces: org.Spaces}, true
+}
+
+// add records that the org with guid is complete
+func (cp *crawlCheckpoint) add(guid string, res *crawlResult) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	org := &checkpointOrg{GUID: guid, Items: res.Items, Spaces: res.Spaces}
+	cp.orgs[guid] = org
+	return cp.write(org)
+}
+
+// Close closes the checkpoint file, leaving it in place to resume from
+func (cp *crawlCheckpoint) Close() error {
+	if cp == nil {
+		return nil
+	}
+	return cp.f.Close()
+}
+
+// remove deletes the checkpoint file, once it is no longer needed
+func (cp *crawlCheckpoint) remove() error {
+	if cp == nil {
+		return nil
+	}
+	cp.f.Close()
+	return os.Remove(cp.path)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointResumesCrawl(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetList("/v2/organizations", v2Org("org-1", "org-one"), v2Org("org-2", "org-two"))
+	fcc.addEmptyOrg("org-2")
+	fcc.SetList("/v2/organizations/org-2/managers", v2User("u-4", "dave"))
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+
+	// first crawl is interrupted while on org-two
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cp, err := openCheckpoint(path, &checkpointHeader{API: fcc.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &cancellingClient{ccClient: fcc.client(), url: "/v2/organizations/org-2/auditors", cancel: cancel}
+	_, err = (&reportUsers{}).crawlUsers(ctx, client, &crawlOptions{Checkpoint: cp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	// simulate being killed part way through writing the next line
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"guid": "org-2", "ite`)
+	f.Close()
+
+	// second crawl skips org-one
+	cp, err = openCheckpoint(path, &checkpointHeader{API: fcc.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	before := len(fcc.Requests())
+	res, err := (&reportUsers{}).crawlUsers(context.Background(), fcc.client(), &crawlOptions{Checkpoint: cp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 6 || len(res.Errors) != 0 {
+		t.Fatalf("expected all 6 role assignments, got %d with %d errors", len(res.Items), len(res.Errors))
+	}
+	for _, r := range fcc.Requests()[before:] {
+		if strings.Contains(r, "org-1") || strings.Contains(r, "space-1") {
+			t.Fatalf("expected org-one to be skipped, but requested %s", r)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 3 {
+		t.Fatalf("expected a header and two orgs in the checkpoint, got %d lines:\n%s", lines, b)
+	}
+
+	_, err = openCheckpoint(path, &checkpointHeader{API: "https://api.other.example.com"})
+	if err == nil {
+		t.Fatal("expected a checkpoint for another API to be refused")
+	}
+	for _, header := range []*checkpointHeader{
+		{API: fcc.URL, IncludeOrgUsers: true},
+		{API: fcc.URL, OrgSelector: "env=prod"},
+		{API: fcc.URL, SpaceSelector: "env=prod"},
+	} {
+		_, err = openCheckpoint(path, header)
+		if err == nil {
+			t.Fatalf("expected a checkpoint started with other options to be refused: %s", header.options())
+		}
+	}
+}
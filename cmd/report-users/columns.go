@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// column is a field of userInfoLineItem that can be shown in tabular output
+type column struct {
+	Name   string
+	Header string
+	Value  func(*userInfoLineItem) string
+}
+
+// allColumns lists every column that can be selected with --columns
+var allColumns = []*column{
+	{"foundation", "Foundation", func(i *userInfoLineItem) string { return i.Foundation }},
+	{"organization", "Organization", func(i *userInfoLineItem) string { return i.Organization }},
+	{"space", "Space", func(i *userInfoLineItem) string { return i.Space }},
+	{"username", "Username", func(i *userInfoLineItem) string { return i.Username }},
+	{"role", "Role", func(i *userInfoLineItem) string { return i.Role }},
+	{"user_guid", "User GUID", func(i *userInfoLineItem) string { return i.UserGUID }},
+	{"origin", "Origin", func(i *userInfoLineItem) string { return i.Origin }},
+	{"email", "Email", func(i *userInfoLineItem) string { return i.Email }},
+	{"last_logon", "Last Logon", func(i *userInfoLineItem) string {
+		if i.LastLogon == nil {
+			return ""
+		}
+		return i.LastLogon.UTC().Format(time.RFC3339)
+	}},
+	{"org_guid", "Org GUID", func(i *userInfoLineItem) string { return i.OrgGUID }},
+	{"isolation_segments", "Isolation Segments", func(i *userInfoLineItem) string { return strings.Join(i.IsolationSegments, ", ") }},
+	{"default_isolation_segment", "Default Isolation Segment", func(i *userInfoLineItem) string { return i.DefaultIsolationSegment }},
+	{"quota", "Quota", func(i *userInfoLineItem) string { return i.Quota }},
+	{"granted_at", "Granted At", func(i *userInfoLineItem) string {
+		if i.GrantedAt == nil {
+			return ""
+		}
+		return i.GrantedAt.UTC().Format(time.RFC3339)
+	}},
+	{"granted_by", "Granted By", func(i *userInfoLineItem) string { return i.GrantedBy }},
+	{"incomplete", "Incomplete", func(i *userInfoLineItem) string {
+		if i.Incomplete {
+			return "yes"
+		}
+		return ""
+	}},
+}
+
+// defaultColumns are shown if --columns is not set
+const defaultColumns = "organization,space,username,role"
+
+func columnNames() []string {
+	var rv []string
+	for _, c := range allColumns {
+		rv = append(rv, c.Name)
+	}
+	return rv
+}
+
+func findColumn(name string) (*column, error) {
+	for _, c := range allColumns {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	if c, ok := metadataColumn(name); ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown column %q, must be one of: %s, or a label or annotation, ie org_label:cost-centre", name, strings.Join(columnNames(), ", "))
+}
+
+// parseColumns returns the columns named in a comma separated list
+func parseColumns(s string) ([]*column, error) {
+	var rv []*column
+	for _, name := range splitList(s) {
+		c, err := findColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, c)
+	}
+	if len(rv) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+	return rv, nil
+}
+
+// withoutColumn returns cols, less the named column
+func withoutColumn(cols []*column, name string) []*column {
+	var rv []*column
+	for _, c := range cols {
+		if c.Name != name {
+			rv = append(rv, c)
+		}
+	}
+	return rv
+}
+
+func hasColumn(cols []*column, names ...string) bool {
+	for _, c := range cols {
+		if matchesAny(names, c.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+type sortKey struct {
+	Column     *column
+	Descending bool
+}
+
+// parseSort parses a comma separated list of column names, each optionally
+// prefixed with "-" to sort in descending order, ie "organization,-last_logon"
+func parseSort(s string) ([]*sortKey, error) {
+	var rv []*sortKey
+	for _, name := range splitList(s) {
+		key := &sortKey{}
+		if strings.HasPrefix(name, "-") {
+			key.Descending = true
+			name = name[1:]
+		} else {
+			name = strings.TrimPrefix(name, "+")
+		}
+		c, err := findColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		key.Column = c
+		rv = append(rv, key)
+	}
+	return rv, nil
+}
+
+// sortItems sorts items in place by each key in turn. Items that are equal
+// on every key keep the order they were crawled in.
+func sortItems(items []*userInfoLineItem, keys []*sortKey) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, k := range keys {
+			a, b := k.Column.Value(items[i]), k.Column.Value(items[j])
+			if a == b {
+				continue
+			}
+			if k.Descending {
+				return a > b
+			}
+			return a < b
+		}
+		return false
+	})
+}
+
+// rowValues returns the values of cols for item
+func rowValues(item *userInfoLineItem, cols []*column) []string {
+	rv := make([]string, len(cols))
+	for i, c := range cols {
+		rv[i] = c.Value(item)
+	}
+	return rv
+}
+
+func columnHeaders(cols []*column) []string {
+	rv := make([]string, len(cols))
+	for i, c := range cols {
+		rv[i] = c.Header
+	}
+	return rv
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortItems(t *testing.T) {
+	logon := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []*userInfoLineItem{
+		{Organization: "b", Username: "alice"},
+		{Organization: "a", Username: "carol", LastLogon: &logon},
+		{Organization: "b", Username: "bob"},
+		{Organization: "a", Username: "dave"},
+	}
+
+	keys, err := parseSort("organization,-username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortItems(items, keys)
+
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Username)
+	}
+	if !reflect.DeepEqual(got, []string{"dave", "carol", "bob", "alice"}) {
+		t.Fatalf("unexpected order: %v", got)
+	}
+
+	if _, err := parseSort("organization,nope"); err == nil {
+		t.Fatal("expected error for unknown sort column")
+	}
+}
+
+func TestWriteCSVReport(t *testing.T) {
+	logon := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	cols, err := parseColumns("username,email,last_logon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = writeCSVReport(&buf, []*userInfoLineItem{
+		{Username: "alice", Email: "alice@example.com", LastLogon: &logon},
+		{Username: "bob, jr"},
+	}, cols, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Username,Email,Last Logon\nalice,alice@example.com,2018-01-02T03:04:05Z\n\"bob, jr\",,\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected csv:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = writeCSVReport(&buf, []*userInfoLineItem{{Username: "alice"}}, cols, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "alice,,\n" {
+		t.Fatalf("unexpected csv without header:\n%s", buf.String())
+	}
+}
+
+func TestLookupUserDetails(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetObject("/v2/info", map[string]string{"token_endpoint": fcc.URL + "/uaa"})
+	fcc.SetObject("/uaa/Users", map[string]interface{}{
+		"resources": []interface{}{
+			map[string]interface{}{
+				"id":            "u-1",
+				"emails":        []interface{}{map[string]interface{}{"value": "alice@example.com", "primary": true}},
+				"lastLogonTime": 1514862245000,
+			},
+		},
+		"totalResults": 1,
+	})
+	fcc.SetList("/v3/users",
+		map[string]string{"guid": "u-1", "origin": "uaa"},
+		map[string]string{"guid": "u-2", "origin": "google"})
+
+	var out bytes.Buffer
+	opts := &reportOptions{
+		OutputFormat: "csv",
+		Columns:      "username,origin,email,last_logon",
+		Sort:         "username",
+		Roles:        "OrgManager,SpaceDeveloper",
+		NoHeader:     true,
+	}
+	_, err := (&reportUsers{}).reportUsers(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "alice,uaa,alice@example.com,2018-01-02T03:04:05Z\nbob,google,,\ncarol,,,\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestOrgContextColumns(t *testing.T) {
+	fcc := newTestFoundation(t)
+	org := v2Org("org-1", "org-one")
+	org["entity"].(map[string]interface{})["quota_definition_guid"] = "q-1"
+	org["entity"].(map[string]interface{})["default_isolation_segment_guid"] = "seg-2"
+	fcc.SetList("/v2/organizations", org, v2Org("org-2", "org-two"))
+	fcc.addEmptyOrg("org-2")
+	fcc.SetList("/v2/organizations/org-2/managers", v2User("u-4", "dave"))
+	fcc.SetList("/v2/quota_definitions", map[string]interface{}{
+		"metadata": map[string]string{"guid": "q-1"},
+		"entity":   map[string]string{"name": "large"},
+	})
+	fcc.SetList("/v3/isolation_segments",
+		map[string]string{"guid": "seg-1", "name": "shared"},
+		map[string]string{"guid": "seg-2", "name": "PROTECTED"})
+	fcc.SetList("/v3/isolation_segments/seg-1/organizations",
+		map[string]string{"guid": "org-1"}, map[string]string{"guid": "org-2"})
+	fcc.SetList("/v3/isolation_segments/seg-2/organizations",
+		map[string]string{"guid": "org-1"})
+
+	var out bytes.Buffer
+	opts := &reportOptions{
+		OutputFormat:      "csv",
+		Columns:           "organization,username,isolation_segments,default_isolation_segment,quota",
+		Roles:             "OrgManager",
+		IsolationSegments: "PROTECTED",
+	}
+	_, err := (&reportUsers{}).reportUsers(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Organization,Username,Isolation Segments,Default Isolation Segment,Quota\n" +
+		"org-one,alice,\"shared, PROTECTED\",PROTECTED,large\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestOrgContextLookupFails(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetList("/v2/quota_definitions")
+	fcc.SetList("/v3/isolation_segments")
+	fcc.Fail("/v3/isolation_segments", maxRetries+1)
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	// without the filter, the columns are left empty
+	var out bytes.Buffer
+	opts := &reportOptions{OutputFormat: "csv", Columns: "username,isolation_segments", Roles: "OrgManager"}
+	_, err := (&reportUsers{}).reportUsers(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Username,Isolation Segments\nalice,\n" {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+
+	// the filter can't be applied, so rather than an empty report it fails
+	fcc.Fail("/v3/isolation_segments", maxRetries+1)
+	out.Reset()
+	opts.IsolationSegments = "PROTECTED"
+	_, err = (&reportUsers{}).reportUsers(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err == nil || !strings.Contains(err.Error(), "isolation segments") {
+		t.Fatalf("expected the lookup error, got %v", err)
+	}
+}
+
+func TestLabelColumnsAndSelectors(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetList("/v2/organizations", v2Org("org-1", "org-one"), v2Org("org-2", "org-two"))
+	fcc.addEmptyOrg("org-2")
+	fcc.SetList("/v2/organizations/org-2/managers", v2User("u-4", "dave"))
+	// the fake ignores label_selector, so only list what would match
+	fcc.SetList("/v3/organizations", map[string]interface{}{
+		"guid": "org-1",
+		"metadata": map[string]interface{}{
+			"labels":      map[string]string{"env": "prod"},
+			"annotations": map[string]string{"contact": "ops@example.com"},
+		},
+	})
+	fcc.SetList("/v3/spaces", map[string]interface{}{
+		"guid":          "space-1",
+		"metadata":      map[string]interface{}{"labels": map[string]string{"tier": "web"}},
+		"relationships": map[string]interface{}{"organization": map[string]interface{}{"data": map[string]string{"guid": "org-1"}}},
+	})
+
+	var out bytes.Buffer
+	opts := &reportOptions{
+		OutputFormat:  "csv",
+		Columns:       "username,role,org_label:env,org_annotation:contact,space_label:tier",
+		Roles:         "OrgManager,SpaceManager",
+		OrgSelector:   "env=prod",
+		SpaceSelector: "tier",
+	}
+	_, err := (&reportUsers{}).reportUsers(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Username,Role,Org env,Org contact,Space tier\n" +
+		"alice,OrgManager,prod,ops@example.com,\n" +
+		"alice,SpaceManager,prod,ops@example.com,web\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+
+	requests := strings.Join(fcc.Requests(), "\n")
+	if !strings.Contains(requests, "/v3/organizations?per_page=5000&label_selector=env%3Dprod") {
+		t.Fatalf("org selector not sent:\n%s", requests)
+	}
+	if strings.Contains(requests, "/v2/organizations/org-2/managers") {
+		t.Fatal("unselected org was crawled")
+	}
+
+	_, err = findColumn("org_label:")
+	if err == nil {
+		t.Fatal("expected an error for a label column with no key")
+	}
+}
+
+// v3Role returns a role fixture for a user in an org or space
+func v3Role(roleType, created, user, org, space string) map[string]interface{} {
+	rel := map[string]interface{}{"user": map[string]interface{}{"data": map[string]string{"guid": user}}}
+	if org != "" {
+		rel["organization"] = map[string]interface{}{"data": map[string]string{"guid": org}}
+	}
+	if space != "" {
+		rel["space"] = map[string]interface{}{"data": map[string]string{"guid": space}}
+	}
+	return map[string]interface{}{"type": roleType, "created_at": created, "relationships": rel}
+}
+
+func TestGrantColumns(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetList("/v3/roles",
+		v3Role("organization_manager", "2020-01-02T03:04:05Z", "u-1", "org-1", ""),
+		v3Role("space_developer", "2023-06-01T00:00:00Z", "u-2", "", "space-1"))
+	event := func(eventType, created, actor, target, targetGUID string) map[string]interface{} {
+		e := v3RoleEvent(eventType, created, actor, target, "", "space-1")
+		e["target"] = map[string]string{"guid": targetGUID, "type": "user", "name": target}
+		return e
+	}
+	fcc.SetList("/v3/audit_events",
+		event("audit.user.space_developer_add", "2023-06-01T00:00:00Z", "admin", "bob", "u-2"),
+		event("audit.user.space_developer_add", "2023-07-01T00:00:00Z", "ops", "carol", "u-3"),
+		event("audit.user.space_developer_remove", "2023-07-02T00:00:00Z", "ops", "carol", "u-3"),
+		event("audit.user.space_developer_add", "2023-07-03T00:00:00Z", "admin", "carol", "u-3"))
+	fcc.SetList("/v3/organizations", map[string]string{"guid": "org-1", "name": "org-one"})
+	fcc.SetList("/v3/spaces", map[string]string{"guid": "space-1", "name": "dev"})
+
+	var out bytes.Buffer
+	opts := &reportOptions{
+		OutputFormat: "csv",
+		Columns:      "username,role,granted_at,granted_by",
+		Roles:        "OrgManager,SpaceDeveloper",
+		Sort:         "granted_at",
+		NoHeader:     true,
+	}
+	_, err := (&reportUsers{}).reportUsers(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// carol has no v3 role, so falls back to the latest grant event
+	expected := "alice,OrgManager,2020-01-02T03:04:05Z,\n" +
+		"bob,SpaceDeveloper,2023-06-01T00:00:00Z,admin\n" +
+		"carol,SpaceDeveloper,2023-07-03T00:00:00Z,admin\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+}
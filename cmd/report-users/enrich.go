@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// lookupOrigins sets the Origin of each item by listing all users from the
+// v3 API. Origins are informational only, so failures are logged and ignored.
+func lookupOrigins(ctx context.Context, client ccClient, items []*userInfoLineItem) {
+	origins := make(map[string]string)
+	err := client.List(ctx, "/v3/users?per_page=5000", func(user *resource) error {
+		origins[user.GUID] = user.Origin
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to look up user origins: %s", err)
+		return
+	}
+	for _, item := range items {
+		item.Origin = origins[item.UserGUID]
+	}
+}
+
+// uaaUser is the part of a UAA SCIM user that we report on
+type uaaUser struct {
+	ID     string `json:"id"`
+	Emails []struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	} `json:"emails"`
+	LastLogonTime int64 `json:"lastLogonTime"` // milliseconds since epoch
+}
+
+// uaaURL returns the UAA url advertised by the API
+func uaaURL(ctx context.Context, client ccClient) (string, error) {
+	var info struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	err := client.Get(ctx, "/v2/info", &info)
+	if err != nil {
+		return "", err
+	}
+	if info.TokenEndpoint == "" {
+		return "", errors.New("no token_endpoint in /v2/info")
+	}
+	return strings.TrimSuffix(info.TokenEndpoint, "/"), nil
+}
+
+// lookupUAADetails sets the Email and LastLogon of each item by listing all
+// users from UAA, which needs the scim.read scope. As with origins, failures
+// are logged and ignored.
+func lookupUAADetails(ctx context.Context, client ccClient, items []*userInfoLineItem) {
+	uaa, err := uaaURL(ctx, client)
+	if err != nil {
+		log.Printf("unable to find UAA: %s", err)
+		return
+	}
+
+	const count = 500
+	users := make(map[string]*uaaUser)
+	for start := 1; ; start += count {
+		var page struct {
+			Resources    []*uaaUser `json:"resources"`
+			TotalResults int        `json:"totalResults"`
+		}
+		err = client.Get(ctx, fmt.Sprintf("%s/Users?attributes=id,emails,lastLogonTime&count=%d&startIndex=%d", uaa, count, start), &page)
+		if err != nil {
+			log.Printf("unable to look up user details from UAA: %s", err)
+			return
+		}
+		for _, u := range page.Resources {
+			users[u.ID] = u
+		}
+		if len(page.Resources) == 0 || start+count > page.TotalResults {
+			break
+		}
+	}
+
+	for _, item := range items {
+		u, ok := users[item.UserGUID]
+		if !ok {
+			continue
+		}
+		for i, e := range u.Emails {
+			if e.Primary || i == 0 {
+				item.Email = e.Value
+			}
+		}
+		if u.LastLogonTime != 0 {
+			t := time.Unix(0, u.LastLogonTime*int64(time.Millisecond))
+			item.LastLogon = &t
+		}
+	}
+}
+
+// lookupOrgContext sets the IsolationSegments, DefaultIsolationSegment and
+// Quota of each item's org. Unlike the other lookups failures are returned,
+// as --isolation-segment filters on the result.
+func lookupOrgContext(ctx context.Context, client ccClient, items []*userInfoLineItem) error {
+	quotas := make(map[string]string)
+	err := client.List(ctx, "/v2/quota_definitions", func(q *resource) error {
+		quotas[q.Metadata.GUID] = q.Entity.Name
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up quotas: %s", err)
+	}
+
+	var segments []*resource
+	err = client.List(ctx, "/v3/isolation_segments?per_page=5000", func(s *resource) error {
+		segments = append(segments, s)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up isolation segments: %s", err)
+	}
+	segmentNames := make(map[string]string)
+	entitled := make(map[string][]string)
+	for _, s := range segments {
+		segmentNames[s.GUID] = s.Name
+		err = client.List(ctx, "/v3/isolation_segments/"+s.GUID+"/organizations?per_page=5000", func(org *resource) error {
+			entitled[org.GUID] = append(entitled[org.GUID], s.Name)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to look up orgs for isolation segment %s: %s", s.Name, err)
+		}
+	}
+
+	orgs := make(map[string]*resource)
+	err = client.List(ctx, "/v2/organizations", func(org *resource) error {
+		orgs[org.Metadata.GUID] = org
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up orgs: %s", err)
+	}
+
+	for _, item := range items {
+		org, ok := orgs[item.OrgGUID]
+		if !ok {
+			continue
+		}
+		item.IsolationSegments = entitled[item.OrgGUID]
+		item.DefaultIsolationSegment = segmentNames[org.Entity.DefaultSegmentGUID]
+		item.Quota = quotas[org.Entity.QuotaGUID]
+	}
+	return nil
+}
+
+// grantKey identifies a role assignment, ie "SpaceDeveloper/user-guid/space-guid"
+func grantKey(role, userGUID, orgGUID, spaceGUID string) string {
+	if spaceGUID != "" {
+		return role + "/" + userGUID + "/" + spaceGUID
+	}
+	return role + "/" + userGUID + "/" + orgGUID
+}
+
+// lookupGrants sets GrantedAt from the created_at of each item's v3 role, and
+// GrantedBy from the most recent audit event granting it. GrantedAt falls
+// back to that event where there is no v3 role. Audit events are only kept
+// for a while, so older grants have no GrantedBy. As with origins, failures
+// are logged and ignored.
+func lookupGrants(ctx context.Context, client ccClient, items []*userInfoLineItem) {
+	created := make(map[string]time.Time)
+	err := client.List(ctx, "/v3/roles?per_page=5000", func(role *resource) error {
+		name, ok := roleEventRoles[role.Type]
+		if !ok {
+			return nil
+		}
+		rel := role.Relationships
+		created[grantKey(name, rel.User.Data.GUID, rel.Organization.Data.GUID, rel.Space.Data.GUID)] = role.CreatedAt
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to look up roles: %s", err)
+	}
+
+	// events are oldest first, so this leaves the latest grant not since revoked
+	grants := make(map[string]*roleEvent)
+	events, err := collectRoleEvents(ctx, client, time.Time{})
+	if err != nil {
+		log.Printf("unable to look up role events: %s", err)
+	}
+	for _, e := range events {
+		key := grantKey(e.Role, e.UserGUID, e.OrgGUID, e.SpaceGUID)
+		if e.Action == "granted" {
+			grants[key] = e
+		} else {
+			delete(grants, key)
+		}
+	}
+
+	for _, item := range items {
+		key := grantKey(item.Role, item.UserGUID, item.OrgGUID, item.SpaceGUID)
+		if t, ok := created[key]; ok && !t.IsZero() {
+			t := t
+			item.GrantedAt = &t
+		}
+		if e, ok := grants[key]; ok {
+			item.GrantedBy = e.Actor
+			if item.GrantedAt == nil {
+				t := e.Time
+				item.GrantedAt = &t
+			}
+		}
+	}
+}
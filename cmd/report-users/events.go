@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventActor is the actor or target of an audit event
+type eventActor struct {
+	GUID string `json:"guid"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// relatedGUID refers to another resource, ie the space of an audit event
+type relatedGUID struct {
+	GUID string `json:"guid"`
+}
+
+// relationship is a v3 relationship to another resource, ie the user of a role
+type relationship struct {
+	Data relatedGUID `json:"data"`
+}
+
+// roleEventRoles maps the role part of an audit event type, ie
+// "audit.user.space_developer_add", to the role name used in reports
+var roleEventRoles = map[string]string{
+	"organization_user":            "OrgUser",
+	"organization_manager":         "OrgManager",
+	"organization_billing_manager": "OrgBillingManager",
+	"organization_auditor":         "OrgAuditor",
+	"space_developer":              "SpaceDeveloper",
+	"space_manager":                "SpaceManager",
+	"space_auditor":                "SpaceAuditor",
+	"space_supporter":              "SpaceSupporter",
+}
+
+// roleEventTypes returns every audit event type for a role being granted or revoked, sorted
+func roleEventTypes() []string {
+	var rv []string
+	for role := range roleEventRoles {
+		rv = append(rv, "audit.user."+role+"_add", "audit.user."+role+"_remove")
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// parseRoleEventType returns the role and action, "granted" or "revoked", of
+// an audit event type, or false if it isn't a role event
+func parseRoleEventType(t string) (role, action string, ok bool) {
+	t = strings.TrimPrefix(t, "audit.user.")
+	switch {
+	case strings.HasSuffix(t, "_add"):
+		role, action = roleEventRoles[strings.TrimSuffix(t, "_add")], "granted"
+	case strings.HasSuffix(t, "_remove"):
+		role, action = roleEventRoles[strings.TrimSuffix(t, "_remove")], "revoked"
+	}
+	return role, action, role != ""
+}
+
+// parseSince returns the time given by --since, which is either a duration
+// before now, ie "30d" or "12h", or a date or RFC3339 time
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, must be a duration such as 30d or 12h, or a date such as 2024-01-31", s)
+}
+
+// roleEvent is a role being granted to, or revoked from, a user
+type roleEvent struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	Role         string    `json:"role"`
+	Username     string    `json:"username"`
+	UserGUID     string    `json:"user_guid"`
+	Organization string    `json:"organization"`
+	OrgGUID      string    `json:"org_guid"`
+	Space        string    `json:"space,omitempty"`
+	SpaceGUID    string    `json:"space_guid,omitempty"`
+
+	// Actor is who made the change, which may be a user or a UAA client
+	Actor     string `json:"actor"`
+	ActorGUID string `json:"actor_guid"`
+}
+
+// collectRoleEvents returns every role granted or revoked since, oldest
+// first, from the v3 audit events. If since is zero, all events still kept
+// are returned. Org and space names are looked up, as events only refer to
+// them by GUID, and are left empty for those since deleted.
+func collectRoleEvents(ctx context.Context, client ccClient, since time.Time) ([]*roleEvent, error) {
+	q := url.Values{
+		"types":    {strings.Join(roleEventTypes(), ",")},
+		"order_by": {"created_at"},
+		"per_page": {"5000"},
+	}
+	if !since.IsZero() {
+		q.Set("created_ats[gt]", since.UTC().Format(time.RFC3339))
+	}
+	rv := []*roleEvent{}
+	err := client.List(ctx, "/v3/audit_events?"+q.Encode(), func(e *resource) error {
+		role, action, ok := parseRoleEventType(e.Type)
+		if !ok {
+			return nil
+		}
+		rv = append(rv, &roleEvent{
+			Time:      e.CreatedAt,
+			Action:    action,
+			Role:      role,
+			Username:  e.Target.Name,
+			UserGUID:  e.Target.GUID,
+			OrgGUID:   e.Organization.GUID,
+			SpaceGUID: e.Space.GUID,
+			Actor:     e.Actor.Name,
+			ActorGUID: e.Actor.GUID,
+		})
+		return nil
+	})
+	if err != nil || len(rv) == 0 {
+		return rv, err
+	}
+
+	orgs := make(map[string]string)
+	err = client.List(ctx, "/v3/organizations?per_page=5000", func(org *resource) error {
+		orgs[org.GUID] = org.Name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	spaces := make(map[string]*resource)
+	err = client.List(ctx, "/v3/spaces?per_page=5000", func(space *resource) error {
+		spaces[space.GUID] = space
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range rv {
+		if space, ok := spaces[e.SpaceGUID]; ok {
+			e.Space = space.Name
+			if e.OrgGUID == "" {
+				e.OrgGUID = space.Relationships.Organization.Data.GUID
+			}
+		}
+		e.Organization = orgs[e.OrgGUID]
+	}
+	return rv, nil
+}
+
+// validateRoleEvents checks the options used by report-role-events
+func (o *reportOptions) validateRoleEvents() error {
+	if !matchesAny([]string{"table", "json", "csv", "markdown"}, o.format()) {
+		return fmt.Errorf("report-role-events does not support %s output", o.format())
+	}
+	_, err := parseSince(o.Since, time.Now())
+	if err != nil {
+		return err
+	}
+	return checkCompression(o.OutputFile, o.SaveArchive, o.FromArchive)
+}
+
+// reportRoleEvents runs the report-role-events command
+func (c *reportUsers) reportRoleEvents(conn cfConnection, opts *reportOptions) error {
+	err := opts.validateRoleEvents()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := interruptible(opts.Timeout)
+	defer cancel()
+	client, archive, closeTrace, err := c.newTracedClient(conn, opts)
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+
+	now := time.Now()
+	err = writeOutput(expandOutputPath(opts.OutputFile, client.API, now), func(out io.Writer) error {
+		return c.roleEventsReport(ctx, client, out, opts, now)
+	})
+	if err != nil {
+		return err
+	}
+	if opts.SaveArchive != "" {
+		return archive.save(expandOutputPath(opts.SaveArchive, client.API, now))
+	}
+	return nil
+}
+
+// roleEventsReport writes the role events since --since, filtered by opts, to out
+func (c *reportUsers) roleEventsReport(ctx context.Context, client ccClient, out io.Writer, opts *reportOptions, now time.Time) error {
+	since, err := parseSince(opts.Since, now)
+	if err != nil {
+		return err
+	}
+	all, err := collectRoleEvents(ctx, client, since)
+	if err != nil {
+		return err
+	}
+
+	f := opts.filter()
+	events := []*roleEvent{}
+	for _, e := range all {
+		if matchesAny(f.Organizations, e.Organization) && matchesAny(f.Spaces, e.Space) &&
+			matchesAny(f.Usernames, e.Username) && matchesAny(f.Roles, e.Role) {
+			events = append(events, e)
+		}
+	}
+
+	if opts.format() == "json" {
+		return json.NewEncoder(out).Encode(events)
+	}
+	header := []string{"Time", "Action", "Role", "Username", "Organization", "Space", "By"}
+	var rows [][]string
+	for _, e := range events {
+		rows = append(rows, []string{
+			e.Time.UTC().Format(time.RFC3339),
+			e.Action,
+			e.Role,
+			e.Username,
+			e.Organization,
+			e.Space,
+			e.Actor,
+		})
+	}
+	return writeRows(out, header, rows, opts)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	for s, expected := range map[string]time.Time{
+		"30d":                  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		"12h":                  time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+		"2024-01-31":           time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		"2024-01-31T10:00:00Z": time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC),
+	} {
+		got, err := parseSince(s, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(expected) {
+			t.Errorf("%s: got %s, expected %s", s, got, expected)
+		}
+	}
+	for _, s := range []string{"", "d", "-3d", "last week"} {
+		_, err := parseSince(s, now)
+		if err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+// v3RoleEvent returns an audit event fixture for a role change
+func v3RoleEvent(eventType, created, actor, target, org, space string) map[string]interface{} {
+	return map[string]interface{}{
+		"guid":         "event-" + created,
+		"type":         eventType,
+		"created_at":   created,
+		"actor":        map[string]string{"guid": actor + "-guid", "type": "user", "name": actor},
+		"target":       map[string]string{"guid": target + "-guid", "type": "user", "name": target},
+		"organization": map[string]string{"guid": org},
+		"space":        map[string]string{"guid": space},
+	}
+}
+
+func TestRoleEventsReport(t *testing.T) {
+	fcc := newFakeCloudController(t)
+	fcc.SetList("/v3/audit_events",
+		v3RoleEvent("audit.user.space_developer_add", "2024-03-02T10:00:00Z", "admin", "bob", "", "space-1"),
+		v3RoleEvent("audit.user.organization_manager_remove", "2024-03-03T10:00:00Z", "admin", "alice", "org-1", ""),
+		v3RoleEvent("audit.app.start", "2024-03-04T10:00:00Z", "bob", "my-app", "org-1", "space-1"))
+	fcc.SetList("/v3/organizations", map[string]string{"guid": "org-1", "name": "org-one"})
+	fcc.SetList("/v3/spaces", map[string]interface{}{
+		"guid":          "space-1",
+		"name":          "dev",
+		"relationships": map[string]interface{}{"organization": map[string]interface{}{"data": map[string]string{"guid": "org-1"}}},
+	})
+
+	var out bytes.Buffer
+	opts := &reportOptions{OutputFormat: "csv", Since: "30d"}
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	err := (&reportUsers{}).roleEventsReport(context.Background(), fcc.client(), &out, opts, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Time,Action,Role,Username,Organization,Space,By\n" +
+		"2024-03-02T10:00:00Z,granted,SpaceDeveloper,bob,org-one,dev,admin\n" +
+		"2024-03-03T10:00:00Z,revoked,OrgManager,alice,org-one,,admin\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+
+	requests := strings.Join(fcc.Requests(), "\n")
+	if !strings.Contains(requests, "created_ats%5Bgt%5D=2024-03-01T12%3A00%3A00Z") ||
+		!strings.Contains(requests, "audit.user.space_developer_add") {
+		t.Fatalf("events not filtered by time and type:\n%s", requests)
+	}
+}
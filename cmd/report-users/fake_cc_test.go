@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCloudController is an in-process Cloud Controller that serves canned
+// fixtures. List endpoints are paginated in the v2 ("next_url") or v3
+// ("pagination.next.href") style depending on their path.
+type fakeCloudController struct {
+	*httptest.Server
+
+	// PageSize is the number of resources returned per page of a list
+	PageSize int
+
+	mu       sync.Mutex
+	lists    map[string][]interface{}
+	objects  map[string]interface{}
+	failures map[string]int
+	replays  map[string]json.RawMessage
+	tokens   map[string]int
+	requests []string
+}
+
+const fakeAuthorization = "bearer fake-token"
+
+func newFakeCloudController(t *testing.T) *fakeCloudController {
+	fcc := &fakeCloudController{
+		PageSize: 2,
+		lists:    make(map[string][]interface{}),
+		objects:  make(map[string]interface{}),
+		failures: make(map[string]int),
+		replays:  make(map[string]json.RawMessage),
+		tokens:   map[string]int{fakeAuthorization: -1},
+	}
+	fcc.Server = httptest.NewServer(http.HandlerFunc(fcc.serveHTTP))
+	t.Cleanup(fcc.Close)
+	return fcc
+}
+
+// client returns a simpleClient pointed at the fake
+func (fcc *fakeCloudController) client() *simpleClient {
+	return &simpleClient{
+		API:           fcc.URL,
+		Authorization: fakeAuthorization,
+		client:        fcc.Client(),
+	}
+}
+
+// SetList sets the resources that will be paged through at path
+func (fcc *fakeCloudController) SetList(path string, resources ...interface{}) {
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+	fcc.lists[path] = append([]interface{}{}, resources...)
+}
+
+// SetObject sets a single (non-list) response for path
+func (fcc *fakeCloudController) SetObject(path string, obj interface{}) {
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+	fcc.objects[path] = obj
+}
+
+// Fail makes the next n requests for path fail with a server error
+func (fcc *fakeCloudController) Fail(path string, n int) {
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+	fcc.failures[path] = n
+}
+
+// AcceptToken accepts Authorization header token as well as fakeAuthorization
+func (fcc *fakeCloudController) AcceptToken(token string) {
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+	fcc.tokens[token] = -1
+}
+
+// ExpireToken accepts token for only n more requests, after which it is refused with a 401
+func (fcc *fakeCloudController) ExpireToken(token string, n int) {
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+	fcc.tokens[token] = n
+}
+
+// authorized returns true if token is accepted, and counts it against any expiry.
+// Must be called with mu held.
+func (fcc *fakeCloudController) authorized(token string) bool {
+	n, ok := fcc.tokens[token]
+	if !ok || n == 0 {
+		return false
+	}
+	if n > 0 {
+		fcc.tokens[token]--
+	}
+	return true
+}
+
+// Replay serves the successful responses recorded in a --trace-file, by request URI
+func (fcc *fakeCloudController) Replay(t *testing.T, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var e traceEntry
+		err = dec.Decode(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Status == http.StatusOK {
+			fcc.replays[u.RequestURI()] = e.Body
+		}
+	}
+}
+
+// Requests returns all request URIs seen so far
+func (fcc *fakeCloudController) Requests() []string {
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+	return append([]string{}, fcc.requests...)
+}
+
+func (fcc *fakeCloudController) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fcc.mu.Lock()
+	defer fcc.mu.Unlock()
+
+	fcc.requests = append(fcc.requests, r.URL.RequestURI())
+
+	if !fcc.authorized(r.Header.Get("Authorization")) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if fcc.failures[r.URL.Path] > 0 {
+		fcc.failures[r.URL.Path]--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if body, ok := fcc.replays[r.URL.RequestURI()]; ok {
+		w.Write(body)
+		return
+	}
+
+	if obj, ok := fcc.objects[r.URL.Path]; ok {
+		json.NewEncoder(w).Encode(obj)
+		return
+	}
+
+	all, ok := fcc.lists[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		var err error
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			http.Error(w, "bad page", http.StatusBadRequest)
+			return
+		}
+	}
+
+	totalPages := (len(all) + fcc.PageSize - 1) / fcc.PageSize
+	start := (page - 1) * fcc.PageSize
+	end := start + fcc.PageSize
+	if start > len(all) {
+		start = len(all)
+	}
+	if end > len(all) {
+		end = len(all)
+	}
+	resources := all[start:end]
+
+	var nextPath string
+	if page < totalPages {
+		nextPath = fmt.Sprintf("%s?page=%d", r.URL.Path, page+1)
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/v3/") {
+		var next interface{}
+		if nextPath != "" {
+			next = map[string]string{"href": fcc.URL + nextPath}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pagination": map[string]interface{}{
+				"total_results": len(all),
+				"total_pages":   totalPages,
+				"next":          next,
+			},
+			"resources": resources,
+		})
+		return
+	}
+
+	var next interface{}
+	if nextPath != "" {
+		next = nextPath
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total_results": len(all),
+		"total_pages":   totalPages,
+		"next_url":      next,
+		"resources":     resources,
+	})
+}
+
+// v2Org returns an org fixture, with role and space URLs under /v2/organizations/guid
+func v2Org(guid, name string) map[string]interface{} {
+	base := "/v2/organizations/" + guid
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"guid": guid},
+		"entity": map[string]interface{}{
+			"name":                 name,
+			"spaces_url":           base + "/spaces",
+			"users_url":            base + "/users",
+			"managers_url":         base + "/managers",
+			"billing_managers_url": base + "/billing_managers",
+			"auditors_url":         base + "/auditors",
+		},
+	}
+}
+
+// v2Space returns a space fixture, with role URLs under /v2/spaces/guid
+func v2Space(guid, name string) map[string]interface{} {
+	base := "/v2/spaces/" + guid
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"guid": guid},
+		"entity": map[string]interface{}{
+			"name":           name,
+			"developers_url": base + "/developers",
+			"managers_url":   base + "/managers",
+			"auditors_url":   base + "/auditors",
+		},
+	}
+}
+
+// v2User returns a user fixture
+func v2User(guid, username string) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"guid": guid},
+		"entity":   map[string]interface{}{"username": username},
+	}
+}
+
+// addEmptyOrg registers an org, and empty lists for all of its role and space URLs
+func (fcc *fakeCloudController) addEmptyOrg(guid string) {
+	base := "/v2/organizations/" + guid
+	for _, p := range []string{"/spaces", "/users", "/managers", "/billing_managers", "/auditors"} {
+		fcc.SetList(base + p)
+	}
+}
+
+// addEmptySpace registers empty lists for all of a space's role URLs
+func (fcc *fakeCloudController) addEmptySpace(guid string) {
+	base := "/v2/spaces/" + guid
+	for _, p := range []string{"/developers", "/managers", "/auditors"} {
+		fcc.SetList(base + p)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+// roleFilter selects line items. Each non-empty field lists the values
+// allowed for that field, and an item must match all non-empty fields.
+type roleFilter struct {
+	Organizations []string
+	Spaces        []string
+	Usernames     []string
+	Roles         []string
+	UserGUIDs     []string
+	Segments      []string // isolation segments, matching if the org is entitled to any
+}
+
+func matchesAny(allowed []string, v string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyOf returns true if any of values is allowed
+func matchesAnyOf(allowed []string, values []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if matchesAny(allowed, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if the item is selected by the filter
+func (f *roleFilter) match(item *userInfoLineItem) bool {
+	return matchesAny(f.Organizations, item.Organization) &&
+		matchesAny(f.Spaces, item.Space) &&
+		matchesAny(f.Usernames, item.Username) &&
+		matchesAny(f.Roles, item.Role) &&
+		matchesAny(f.UserGUIDs, item.UserGUID) &&
+		matchesAnyOf(f.Segments, item.IsolationSegments)
+}
+
+// apply returns the items selected by the filter
+func (f *roleFilter) apply(items []*userInfoLineItem) []*userInfoLineItem {
+	rv := []*userInfoLineItem{}
+	for _, item := range items {
+		if f.match(item) {
+			rv = append(rv, item)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// foundationConfig is one entry in a --foundations file, ie:
+//
+//	{
+//	  "foundations": [
+//	    {"name": "dev", "api": "https://api.dev.example.com", "client_id": "report-users", "client_secret_env": "DEV_SECRET"},
+//	    {"name": "prod", "api": "https://api.prod.example.com", "refresh_token_env": "PROD_REFRESH_TOKEN"}
+//	  ]
+//	}
+//
+// Secrets may be given directly, or read from the named environment variable.
+// TLS and proxy settings not given for a foundation are taken from the command line.
+type foundationConfig struct {
+	Name               string `json:"name"`
+	API                string `json:"api"`
+	Token              string `json:"token"`
+	ClientID           string `json:"client_id"`
+	ClientSecret       string `json:"client_secret"`
+	ClientSecretEnv    string `json:"client_secret_env"`
+	RefreshToken       string `json:"refresh_token"`
+	RefreshTokenEnv    string `json:"refresh_token_env"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	CACert             string `json:"ca_cert"`
+	ClientCert         string `json:"client_cert"`
+	ClientKey          string `json:"client_key"`
+	Proxy              string `json:"proxy"`
+}
+
+// loadFoundations reads a --foundations config file
+func loadFoundations(path string) ([]*foundationConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config struct {
+		Foundations []*foundationConfig `json:"foundations"`
+	}
+	err = json.NewDecoder(f).Decode(&config)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Foundations) == 0 {
+		return nil, errors.New("no foundations listed in " + path)
+	}
+
+	seen := make(map[string]bool)
+	for _, fc := range config.Foundations {
+		if fc.Name == "" || fc.API == "" {
+			return nil, errors.New("every foundation needs a name and api")
+		}
+		if seen[fc.Name] {
+			return nil, fmt.Errorf("foundation %s is listed more than once", fc.Name)
+		}
+		seen[fc.Name] = true
+	}
+	return config.Foundations, nil
+}
+
+// connection returns a uaaConnection for the foundation, which fetches tokens using client
+func (fc *foundationConfig) connection(client *http.Client) *uaaConnection {
+	rv := &uaaConnection{
+		API:          fc.API,
+		Token:        fc.Token,
+		ClientID:     fc.ClientID,
+		ClientSecret: fc.ClientSecret,
+		RefreshToken: fc.RefreshToken,
+		client:       client,
+	}
+	if fc.ClientSecretEnv != "" {
+		rv.ClientSecret = os.Getenv(fc.ClientSecretEnv)
+	}
+	if fc.RefreshTokenEnv != "" {
+		rv.RefreshToken = os.Getenv(fc.RefreshTokenEnv)
+	}
+	return rv
+}
+
+// httpClient returns a client with the foundation's TLS and proxy settings,
+// falling back to those from the command line
+func (fc *foundationConfig) httpClient(opts *reportOptions) (*http.Client, error) {
+	t := opts.transport()
+	t.InsecureSkipVerify = t.InsecureSkipVerify || fc.InsecureSkipVerify
+	if fc.CACert != "" {
+		t.CACert = fc.CACert
+	}
+	if fc.ClientCert != "" || fc.ClientKey != "" {
+		t.ClientCert, t.ClientKey = fc.ClientCert, fc.ClientKey
+	}
+	if fc.Proxy != "" {
+		t.Proxy = fc.Proxy
+	}
+	return t.httpClient()
+}
+
+// collectFoundations crawls each foundation concurrently, and merges the
+// results in the order the foundations are listed
+func (c *reportUsers) collectFoundations(ctx context.Context, foundations []*foundationConfig, opts *reportOptions, progress *crawlProgress) (*crawlResult, error) {
+	start := time.Now()
+	results := make([]*crawlResult, len(foundations))
+	errs := make([]error, len(foundations))
+
+	var wg sync.WaitGroup
+	for i, fc := range foundations {
+		wg.Add(1)
+		go func(i int, fc *foundationConfig) {
+			defer wg.Done()
+
+			httpClient, err := fc.httpClient(opts)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			client, err := newSimpleClient(fc.connection(httpClient), opts.Verbose, httpClient)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			client.Progress = progress
+			client.Timeout = opts.RequestTimeout
+			co := opts.crawlOptions()
+			co.Progress = progress
+			results[i], errs[i] = c.collectUsers(ctx, client, opts, co)
+		}(i, fc)
+	}
+	wg.Wait()
+
+	rv := &crawlResult{}
+	for i, fc := range foundations {
+		if errs[i] != nil && opts.ContinueOnError {
+			// report on the foundations we could reach
+			rv.Errors = append(rv.Errors, &crawlError{Foundation: fc.Name, URL: fc.API, Error: errs[i].Error()})
+			continue
+		}
+		if errs[i] != nil {
+			return nil, fmt.Errorf("%s: %s", fc.Name, errs[i])
+		}
+		for _, item := range results[i].Items {
+			item.Foundation = fc.Name
+		}
+		for _, s := range results[i].Spaces {
+			s.Foundation = fc.Name
+			rv.Spaces = append(rv.Spaces, s)
+		}
+		for _, e := range results[i].Errors {
+			e.Foundation = fc.Name
+			rv.Errors = append(rv.Errors, e)
+		}
+		rv.Items = append(rv.Items, results[i].Items...)
+	}
+	rv.Duration = time.Since(start)
+	return rv, nil
+}
+
+// reportFoundations crawls every foundation in the --foundations file, and writes one merged report
+func (c *reportUsers) reportFoundations(ctx context.Context, opts *reportOptions) error {
+	foundations, err := loadFoundations(opts.Foundations)
+	if err != nil {
+		return err
+	}
+	var res *crawlResult
+	err = writeOutput(expandOutputPath(opts.OutputFile, "all-foundations", time.Now()), func(out io.Writer) error {
+		progress := opts.progress()
+		stop := opts.showProgress(progress)
+		res, err = c.collectFoundations(ctx, foundations, opts, progress)
+		stop()
+		if err != nil {
+			return err
+		}
+		return c.writeResult(out, res, opts)
+	})
+	if err != nil {
+		return err
+	}
+	return checkComplete(os.Stderr, res, "roles")
+}
+
+// crossFoundationUser is a user, matched by username, with access to more than one foundation
+type crossFoundationUser struct {
+	Username    string   `json:"username"`
+	Foundations []string `json:"foundations"`
+	Roles       int      `json:"roles"`
+}
+
+// crossFoundationUsers returns users who hold roles in more than one
+// foundation. Users are matched by username, as GUIDs differ between foundations.
+func crossFoundationUsers(items []*userInfoLineItem) []*crossFoundationUser {
+	byName := make(map[string]*crossFoundationUser)
+	foundations := make(map[string]map[string]bool)
+	for _, item := range items {
+		u, ok := byName[item.Username]
+		if !ok {
+			u = &crossFoundationUser{Username: item.Username}
+			byName[item.Username] = u
+			foundations[item.Username] = make(map[string]bool)
+		}
+		u.Roles++
+		if !foundations[item.Username][item.Foundation] {
+			foundations[item.Username][item.Foundation] = true
+			u.Foundations = append(u.Foundations, item.Foundation)
+		}
+	}
+
+	rv := []*crossFoundationUser{}
+	for _, u := range byName {
+		if len(u.Foundations) > 1 {
+			sort.Strings(u.Foundations)
+			rv = append(rv, u)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Username < rv[j].Username
+	})
+	return rv
+}
+
+// writeCrossFoundationReport writes the users with access to more than one foundation
+func writeCrossFoundationReport(out io.Writer, items []*userInfoLineItem, opts *reportOptions) error {
+	users := crossFoundationUsers(items)
+	if opts.format() == "json" {
+		return json.NewEncoder(out).Encode(users)
+	}
+
+	header := []string{"Username", "Foundations", "Roles"}
+	var rows [][]string
+	for _, u := range users {
+		rows = append(rows, []string{u.Username, strings.Join(u.Foundations, ", "), fmt.Sprint(u.Roles)})
+	}
+
+	switch opts.format() {
+	case "", "table":
+		table := tablewriter.NewWriter(out)
+		if !opts.NoHeader {
+			table.SetHeader(header)
+		}
+		table.AppendBulk(rows)
+		table.Render()
+		return nil
+	case "csv":
+		w := csv.NewWriter(out)
+		if !opts.NoHeader {
+			w.Write(header)
+		}
+		w.WriteAll(rows)
+		return w.Error()
+	case "markdown":
+		w := bufio.NewWriter(out)
+		writeMarkdownRows(w, header, rows)
+		return w.Flush()
+	default:
+		return fmt.Errorf("--cross-foundation does not support %s output", opts.format())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportFoundations(t *testing.T) {
+	dev := newTestFoundation(t)
+	prod := newFakeCloudController(t)
+	prod.SetList("/v2/organizations", v2Org("org-p", "prod-org"))
+	prod.addEmptyOrg("org-p")
+	prod.SetList("/v2/organizations/org-p/managers", v2User("p-1", "alice"), v2User("p-2", "dave"))
+
+	path := filepath.Join(t.TempDir(), "foundations.json")
+	err := ioutil.WriteFile(path, []byte(`{"foundations": [
+		{"name": "dev", "api": "`+dev.URL+`", "token": "fake-token"},
+		{"name": "prod", "api": "`+prod.URL+`", "token": "fake-token"}
+	]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foundations, err := loadFoundations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &reportUsers{}
+	opts := &reportOptions{Quiet: true, Foundations: path, OutputFormat: "csv"}
+	res, err := c.collectFoundations(context.Background(), foundations, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = c.writeResult(&out, res, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if lines[0] != "Foundation,Organization,Space,Username,Role" {
+		t.Fatalf("expected foundation column by default, got: %s", lines[0])
+	}
+	if lines[1] != "dev,org-one,,alice,OrgManager" || lines[len(lines)-1] != "prod,prod-org,,dave,OrgManager" {
+		t.Fatalf("unexpected merged report:\n%s", out.String())
+	}
+
+	out.Reset()
+	opts.CrossFoundation = true
+	err = c.writeResult(&out, res, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Username,Foundations,Roles\nalice,\"dev, prod\",3\n" {
+		t.Fatalf("unexpected cross-foundation report:\n%s", out.String())
+	}
+}
+
+func TestGroupByOrgFoundations(t *testing.T) {
+	items := []*userInfoLineItem{
+		{Foundation: "prod", Organization: "system", Username: "alice", Role: "OrgManager"},
+		{Foundation: "dev", Organization: "system", Space: "apps", Username: "bob", Role: "SpaceDeveloper"},
+		{Foundation: "dev", Organization: "system", Username: "carol", Role: "OrgAuditor"},
+	}
+
+	orgs := groupByOrg(items)
+	if len(orgs) != 2 || orgs[0].Name != "system (dev)" || len(orgs[0].Items) != 2 || orgs[1].Name != "system (prod)" {
+		t.Fatalf("expected orgs with the same name in each foundation to be kept apart, got %d groups", len(orgs))
+	}
+
+	g := newAccessGraph(items)
+	var labels []string
+	for _, n := range g.Nodes {
+		if n.Kind == "org" {
+			labels = append(labels, n.Label)
+		}
+	}
+	if strings.Join(labels, ",") != "system (prod),system (dev)" {
+		t.Fatalf("unexpected org nodes: %q", labels)
+	}
+
+	cols, err := parseColumns("username,role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = writeMarkdownReport(&buf, items, cols, "org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "## system (dev)") || !strings.Contains(buf.String(), "## system (prod)") {
+		t.Fatalf("expected a section per foundation:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// accessGraph has users, orgs and spaces as nodes, and a labelled edge for every role
+type accessGraph struct {
+	Nodes []*graphNode
+	Edges []*graphEdge
+}
+
+type graphNode struct {
+	ID    string
+	Label string
+	Kind  string // user, org or space
+}
+
+type graphEdge struct {
+	From, To string
+	Role     string
+}
+
+// newAccessGraph builds a graph from line items. Spaces are linked to their
+// org, and users to the org or space each role is held in.
+func newAccessGraph(items []*userInfoLineItem) *accessGraph {
+	rv := &accessGraph{}
+	seen := make(map[string]bool)
+	node := func(id, label, kind string) string {
+		if !seen[id] {
+			seen[id] = true
+			rv.Nodes = append(rv.Nodes, &graphNode{ID: id, Label: label, Kind: kind})
+		}
+		return id
+	}
+
+	for _, item := range items {
+		userKey := item.UserGUID
+		if userKey == "" {
+			userKey = item.Username
+		}
+		user := node("user:"+userKey, item.Username, "user")
+		// orgs in different foundations may share a name
+		org := item.Organization
+		if item.Foundation != "" {
+			org = item.Foundation + "/" + org
+		}
+		target := node("org:"+org, orgLabel(item.Foundation, item.Organization), "org")
+		if item.Space != "" {
+			space := "space:" + org + "/" + item.Space
+			if !seen[space] {
+				rv.Edges = append(rv.Edges, &graphEdge{From: target, To: space, Role: "contains"})
+			}
+			target = node(space, item.Space, "space")
+		}
+		rv.Edges = append(rv.Edges, &graphEdge{From: user, To: target, Role: item.Role})
+	}
+	return rv
+}
+
+var dotShapes = map[string]string{
+	"user":  "ellipse",
+	"org":   "box3d",
+	"space": "box",
+}
+
+// writeDOT writes the graph in Graphviz DOT format. If errs is set, the
+// graph is labelled as incomplete.
+func writeDOT(out io.Writer, items []*userInfoLineItem, errs []*crawlError) error {
+	g := newAccessGraph(items)
+	w := bufio.NewWriter(out)
+
+	fmt.Fprintln(w, "digraph access {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	if len(errs) != 0 {
+		fmt.Fprintf(w, "\tlabel=%s;\n\tlabelloc=t;\n", strconv.Quote("Incomplete: "+incompleteSummary(errs, "roles")))
+	}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(w, "\t%s [label=%s, shape=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Label), dotShapes[n.Kind])
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if e.Role == "contains" {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(w, "\t%s -> %s [label=%s%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strconv.Quote(e.Role), style)
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
+
+// writeGraphML writes the graph in GraphML, as read by Gephi and yEd. If errs
+// is set, the graph has an incomplete attribute summarising them.
+func writeGraphML(out io.Writer, items []*userInfoLineItem, errs []*crawlError) error {
+	g := newAccessGraph(items)
+	w := bufio.NewWriter(out)
+
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="label" for="node" attr.name="label" attr.type="string"/>
+  <key id="kind" for="node" attr.name="kind" attr.type="string"/>
+  <key id="role" for="edge" attr.name="role" attr.type="string"/>
+  <key id="incomplete" for="graph" attr.name="incomplete" attr.type="string"/>
+  <graph id="access" edgedefault="directed">
+`)
+	if len(errs) != 0 {
+		fmt.Fprintf(w, "    <data key=\"incomplete\">%s</data>\n", xmlEscape(incompleteSummary(errs, "roles")))
+	}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(w, "    <node id=\"%s\"><data key=\"label\">%s</data><data key=\"kind\">%s</data></node>\n", xmlEscape(n.ID), xmlEscape(n.Label), n.Kind)
+	}
+	for i, e := range g.Edges {
+		fmt.Fprintf(w, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\"><data key=\"role\">%s</data></edge>\n", i, xmlEscape(e.From), xmlEscape(e.To), xmlEscape(e.Role))
+	}
+	fmt.Fprint(w, "  </graph>\n</graphml>\n")
+	return w.Flush()
+}
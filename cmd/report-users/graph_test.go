@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAccessGraph(t *testing.T) {
+	g := newAccessGraph([]*userInfoLineItem{
+		{Organization: "org-one", Username: "alice", UserGUID: "u-1", Role: "OrgManager"},
+		{Organization: "org-one", Space: "dev", Username: "alice", UserGUID: "u-1", Role: "SpaceDeveloper"},
+		{Organization: "org-two", Space: "dev", Username: "alice", UserGUID: "u-1", Role: "SpaceManager"},
+	})
+
+	// one user, two orgs, and a "dev" space in each org
+	if len(g.Nodes) != 5 {
+		t.Fatalf("expected 5 nodes, got %d", len(g.Nodes))
+	}
+	// 3 roles, plus 2 org -> space edges
+	if len(g.Edges) != 5 {
+		t.Fatalf("expected 5 edges, got %d", len(g.Edges))
+	}
+
+	var buf bytes.Buffer
+	err := writeDOT(&buf, []*userInfoLineItem{{Organization: `a "quoted" org`, Username: "bob", Role: "OrgAuditor"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"user:bob" -> "org:a \"quoted\" org" [label="OrgAuditor"];`) {
+		t.Fatalf("unexpected dot:\n%s", buf.String())
+	}
+}
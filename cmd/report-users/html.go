@@ -0,0 +1,210 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"time"
+)
+
+// htmlSpace is the users in one space, or at the org level if Name is empty
+type htmlSpace struct {
+	Name  string
+	Items []*userInfoLineItem
+}
+
+type htmlOrg struct {
+	Name   string
+	Users  int
+	Spaces []*htmlSpace
+
+	// Incomplete is set if some requests for this org failed, so it may be missing roles
+	Incomplete bool
+}
+
+type htmlRoleCount struct {
+	Role  string
+	Count int
+}
+
+// htmlReport is everything rendered by htmlReportTemplate
+type htmlReport struct {
+	Generated time.Time
+
+	// Incomplete summarises Errors, the failed requests, if there were any
+	Incomplete string
+	Errors     []string
+
+	Orgs        []*htmlOrg
+	SpaceCount  int
+	UserCount   int
+	Assignments int
+	Roles       []htmlRoleCount
+}
+
+func newHTMLReport(items []*userInfoLineItem, errs []*crawlError) *htmlReport {
+	rv := &htmlReport{
+		Generated:   time.Now(),
+		Assignments: len(items),
+	}
+	if len(errs) != 0 {
+		rv.Incomplete = incompleteSummary(errs, "roles")
+		for _, e := range errs {
+			rv.Errors = append(rv.Errors, e.String())
+		}
+	}
+
+	allUsers := make(map[string]bool)
+	roles := make(map[string]int)
+	for _, org := range groupByOrg(items) {
+		users := make(map[string]bool)
+		spaces := make(map[string]*htmlSpace)
+		ho := &htmlOrg{Name: org.Name}
+		for _, item := range org.Items {
+			users[item.Username] = true
+			allUsers[item.Username] = true
+			roles[item.Role]++
+			if item.Incomplete {
+				ho.Incomplete = true
+			}
+
+			hs, ok := spaces[item.Space]
+			if !ok {
+				hs = &htmlSpace{Name: item.Space}
+				spaces[item.Space] = hs
+				ho.Spaces = append(ho.Spaces, hs)
+				if item.Space != "" {
+					rv.SpaceCount++
+				}
+			}
+			hs.Items = append(hs.Items, item)
+		}
+		// org level roles sort first, as the empty name
+		sort.SliceStable(ho.Spaces, func(i, j int) bool {
+			return ho.Spaces[i].Name < ho.Spaces[j].Name
+		})
+		ho.Users = len(users)
+		rv.Orgs = append(rv.Orgs, ho)
+	}
+	rv.UserCount = len(allUsers)
+
+	for _, role := range roleOrder {
+		if roles[role] != 0 {
+			rv.Roles = append(rv.Roles, htmlRoleCount{Role: role, Count: roles[role]})
+		}
+	}
+	return rv
+}
+
+// writeHTMLReport writes a single self-contained HTML page, with all styles
+// and scripts inline so that it can be emailed or attached to a ticket. A
+// banner lists errs, if any.
+func writeHTMLReport(out io.Writer, items []*userInfoLineItem, errs []*crawlError) error {
+	return htmlReportTemplate.Execute(out, newHTMLReport(items, errs))
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CloudFoundry user report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+.stats { display: flex; flex-wrap: wrap; gap: 1em; margin-bottom: 1.5em; }
+.stat { border: 1px solid #ddd; border-radius: 4px; padding: 0.5em 1em; }
+.stat b { display: block; font-size: 1.5em; }
+#search { width: 30em; max-width: 100%; padding: 0.4em; margin-bottom: 1em; }
+details { margin: 0.25em 0 0.25em 1em; }
+summary { cursor: pointer; padding: 0.2em 0; }
+summary .count { color: #777; font-size: 0.9em; }
+table { border-collapse: collapse; margin: 0.5em 0 1em 1em; }
+th, td { border: 1px solid #ddd; padding: 0.25em 0.75em; text-align: left; }
+th { background: #f4f4f4; cursor: pointer; user-select: none; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+.badge { display: inline-block; border-radius: 3px; padding: 0.1em 0.5em; font-size: 0.85em; color: #fff; background: #777; }
+.role-OrgManager { background: #b03a2e; }
+.role-OrgBillingManager { background: #7d6608; }
+.role-OrgAuditor { background: #1f618d; }
+.role-OrgUser { background: #808b96; }
+.role-SpaceManager { background: #a04000; }
+.role-SpaceDeveloper { background: #1e8449; }
+.role-SpaceAuditor { background: #2874a6; }
+.incomplete { background: #cb4335; }
+.banner { border: 1px solid #cb4335; border-radius: 4px; background: #fdedec; padding: 0.5em 1em; margin-bottom: 1.5em; }
+.hidden { display: none; }
+</style>
+</head>
+<body>
+<h1>CloudFoundry user report</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}.</p>
+{{if .Incomplete}}
+<div class="banner" id="incomplete">
+<p><b>This report is incomplete.</b> {{.Incomplete}}:</p>
+<ul>
+{{range .Errors}}<li>{{.}}</li>
+{{end}}</ul>
+</div>
+{{end}}
+<div class="stats">
+<div class="stat"><b>{{len .Orgs}}</b>Organizations</div>
+<div class="stat"><b>{{.SpaceCount}}</b>Spaces with users</div>
+<div class="stat"><b>{{.UserCount}}</b>Users</div>
+<div class="stat"><b>{{.Assignments}}</b>Role assignments</div>
+{{range .Roles}}<div class="stat"><b>{{.Count}}</b><span class="badge role-{{.Role}}">{{.Role}}</span></div>
+{{end}}</div>
+
+<input id="search" type="search" placeholder="Search by org, space, username or role">
+
+{{range .Orgs}}<details class="org" open>
+<summary><b>{{.Name}}</b> <span class="count">({{.Users}} users)</span>{{if .Incomplete}} <span class="badge incomplete" title="Some requests for this org failed, so roles may be missing">Incomplete</span>{{end}}</summary>
+{{range .Spaces}}<details class="space" open>
+<summary>{{if .Name}}{{.Name}}{{else}}<i>Organization roles</i>{{end}} <span class="count">({{len .Items}})</span></summary>
+<table>
+<thead><tr><th>Username</th><th>Role</th></tr></thead>
+<tbody>
+{{range .Items}}<tr data-search="{{.Organization}} {{.Space}} {{.Username}} {{.Role}}"><td>{{.Username}}</td><td><span class="badge role-{{.Role}}">{{.Role}}</span></td></tr>
+{{end}}</tbody>
+</table>
+</details>
+{{end}}</details>
+{{end}}
+
+<script>
+(function() {
+	var search = document.getElementById("search");
+	search.addEventListener("input", function() {
+		var terms = search.value.toLowerCase().split(/\s+/).filter(function(t) { return t; });
+		document.querySelectorAll("tr[data-search]").forEach(function(row) {
+			var text = row.getAttribute("data-search").toLowerCase();
+			var match = terms.every(function(t) { return text.indexOf(t) !== -1; });
+			row.classList.toggle("hidden", !match);
+		});
+		document.querySelectorAll("details").forEach(function(d) {
+			var visible = d.querySelector("tr[data-search]:not(.hidden)") !== null;
+			d.classList.toggle("hidden", !visible);
+			if (terms.length) { d.open = visible; }
+		});
+	});
+
+	document.querySelectorAll("th").forEach(function(th) {
+		th.addEventListener("click", function() {
+			var table = th.closest("table");
+			var tbody = table.querySelector("tbody");
+			var col = Array.prototype.indexOf.call(th.parentNode.children, th);
+			var asc = !th.classList.contains("asc");
+			table.querySelectorAll("th").forEach(function(h) { h.classList.remove("asc", "desc"); });
+			th.classList.add(asc ? "asc" : "desc");
+			var rows = Array.prototype.slice.call(tbody.rows);
+			rows.sort(function(a, b) {
+				var x = a.cells[col].textContent, y = b.cells[col].textContent;
+				return asc ? x.localeCompare(y) : y.localeCompare(x);
+			});
+			rows.forEach(function(r) { tbody.appendChild(r); });
+		});
+	});
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteHTMLReport(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeHTMLReport(&buf, []*userInfoLineItem{
+		{Organization: "org-one", Username: "alice", Role: "OrgManager"},
+		{Organization: "org-one", Space: "dev", Username: "<script>", Role: "SpaceDeveloper"},
+		{Organization: "org-one", Space: "dev", Username: "alice", Role: "SpaceManager"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, s := range []string{
+		"<b>org-one</b>",
+		"<i>Organization roles</i>",
+		`<span class="badge role-SpaceDeveloper">SpaceDeveloper</span>`,
+		"<b>2</b>Users",
+		"&lt;script&gt;",
+	} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("expected report to contain %q:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "<td><script>") {
+		t.Fatal("username was not escaped")
+	}
+}
+
+func TestWriteHTMLReportIncomplete(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeHTMLReport(&buf, []*userInfoLineItem{
+		{Organization: "org-one", Username: "alice", Role: "OrgManager"},
+		{Organization: "org-two", Username: "bob", Role: "OrgManager", Incomplete: true},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Count(out, `<span class="badge incomplete"`) != 1 {
+		t.Fatalf("expected only org-two to be marked incomplete:\n%s", out)
+	}
+	if !strings.Contains(out, `<b>org-two</b> <span class="count">(1 users)</span> <span class="badge incomplete"`) {
+		t.Fatalf("expected org-two to be marked incomplete:\n%s", out)
+	}
+}
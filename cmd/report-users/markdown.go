@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownEscape escapes text for use in a GitHub flavoured markdown table cell
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "*", `\*`, "_", `\_`, "`", "\\`").Replace(s)
+}
+
+// writeMarkdownTable writes a table of items, which always has a header row
+// as GitHub flavoured markdown requires one
+func writeMarkdownTable(w io.Writer, cols []*column, items []*userInfoLineItem) {
+	var rows [][]string
+	for _, item := range items {
+		rows = append(rows, rowValues(item, cols))
+	}
+	writeMarkdownRows(w, columnHeaders(cols), rows)
+}
+
+// writeMarkdownRows writes a table with the given header and rows
+func writeMarkdownRows(w io.Writer, header []string, rows [][]string) {
+	fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(header)))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, c := range row {
+			cells[i] = markdownEscape(c)
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	}
+}
+
+// writeMarkdownReport writes GitHub flavoured markdown tables. If groupBy is
+// "org", each org gets a heading with a table of its roles underneath.
+func writeMarkdownReport(out io.Writer, items []*userInfoLineItem, cols []*column, groupBy string) error {
+	w := bufio.NewWriter(out)
+
+	if groupBy == "org" {
+		cols = withoutColumn(cols, "organization")
+		for i, org := range groupByOrg(items) {
+			if i != 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "## %s\n\n", markdownEscape(org.Name))
+			writeMarkdownTable(w, cols, org.Items)
+		}
+		return w.Flush()
+	}
+
+	writeMarkdownTable(w, cols, items)
+	return w.Flush()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteMarkdownReport(t *testing.T) {
+	items := []*userInfoLineItem{
+		{Organization: "org-two", Username: "bob", Role: "OrgManager"},
+		{Organization: "org-one", Space: "prod|space", Username: "alice_a", Role: "SpaceDeveloper"},
+	}
+
+	cols, err := parseColumns(defaultColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = writeMarkdownReport(&buf, items, cols, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `| Organization | Space | Username | Role |
+| --- | --- | --- | --- |
+| org-two |  | bob | OrgManager |
+| org-one | prod\|space | alice\_a | SpaceDeveloper |
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected markdown:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = writeMarkdownReport(&buf, items, cols, "org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `## org-one
+
+| Space | Username | Role |
+| --- | --- | --- |
+| prod\|space | alice\_a | SpaceDeveloper |
+
+## org-two
+
+| Space | Username | Role |
+| --- | --- | --- |
+|  | bob | OrgManager |
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected grouped markdown:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// metadataKinds are the prefixes of label and annotation columns, ie
+// "org_label:cost-centre", with their header and the field they read
+var metadataKinds = []struct {
+	Prefix string
+	Header string
+	Values func(*userInfoLineItem) map[string]string
+}{
+	{"org_label", "Org", func(i *userInfoLineItem) map[string]string { return i.OrgLabels }},
+	{"org_annotation", "Org", func(i *userInfoLineItem) map[string]string { return i.OrgAnnotations }},
+	{"space_label", "Space", func(i *userInfoLineItem) map[string]string { return i.SpaceLabels }},
+	{"space_annotation", "Space", func(i *userInfoLineItem) map[string]string { return i.SpaceAnnotations }},
+}
+
+// metadataColumn returns a column for an org or space label or annotation,
+// named as prefix:key, ie "org_label:cost-centre"
+func metadataColumn(name string) (*column, bool) {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, false
+	}
+	for _, kind := range metadataKinds {
+		if kind.Prefix == parts[0] {
+			key, values := parts[1], kind.Values
+			return &column{name, kind.Header + " " + key, func(i *userInfoLineItem) string {
+				return values(i)[key]
+			}}, true
+		}
+	}
+	return nil, false
+}
+
+func hasMetadataColumn(cols []*column) bool {
+	for _, c := range cols {
+		if _, ok := metadataColumn(c.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listSelected lists the v3 resources at r matching a label selector, ie "env=prod"
+func listSelected(ctx context.Context, client ccClient, r, selector string) ([]*resource, error) {
+	var rv []*resource
+	err := client.List(ctx, r+"?per_page=5000&label_selector="+url.QueryEscape(selector), func(res *resource) error {
+		rv = append(rv, res)
+		return nil
+	})
+	return rv, err
+}
+
+// applySelectors restricts co to the orgs and spaces matching --org-selector
+// and --space-selector. With a space selector, orgs without any matching
+// spaces are skipped too.
+func applySelectors(ctx context.Context, client ccClient, opts *reportOptions, co *crawlOptions) error {
+	if opts.OrgSelector != "" {
+		orgs, err := listSelected(ctx, client, "/v3/organizations", opts.OrgSelector)
+		if err != nil {
+			return err
+		}
+		co.Orgs = make(map[string]bool)
+		for _, org := range orgs {
+			co.Orgs[org.GUID] = true
+		}
+	}
+
+	if opts.SpaceSelector != "" {
+		spaces, err := listSelected(ctx, client, "/v3/spaces", opts.SpaceSelector)
+		if err != nil {
+			return err
+		}
+		co.Spaces = make(map[string]bool)
+		withSpaces := make(map[string]bool)
+		for _, space := range spaces {
+			co.Spaces[space.GUID] = true
+			orgGUID := space.Relationships.Organization.Data.GUID
+			if co.Orgs == nil || co.Orgs[orgGUID] {
+				withSpaces[orgGUID] = true
+			}
+		}
+		co.Orgs = withSpaces
+	}
+	return nil
+}
+
+// lookupMetadata sets the labels and annotations of each item's org and
+// space from the v3 API. As with origins, failures are logged and ignored.
+func lookupMetadata(ctx context.Context, client ccClient, items []*userInfoLineItem) {
+	orgs := make(map[string]*resource)
+	err := client.List(ctx, "/v3/organizations?per_page=5000", func(org *resource) error {
+		orgs[org.GUID] = org
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to look up org labels: %s", err)
+		return
+	}
+	spaces := make(map[string]*resource)
+	err = client.List(ctx, "/v3/spaces?per_page=5000", func(space *resource) error {
+		spaces[space.GUID] = space
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to look up space labels: %s", err)
+		return
+	}
+
+	for _, item := range items {
+		if org, ok := orgs[item.OrgGUID]; ok {
+			item.OrgLabels = org.Metadata.Labels
+			item.OrgAnnotations = org.Metadata.Annotations
+		}
+		if space, ok := spaces[item.SpaceGUID]; ok {
+			item.SpaceLabels = space.Metadata.Labels
+			item.SpaceAnnotations = space.Metadata.Annotations
+		}
+	}
+}
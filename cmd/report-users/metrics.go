@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// crawlMetrics is the state exported as Prometheus metrics
+type crawlMetrics struct {
+	// Crawls and CrawlErrors count all crawls attempted, and those that failed
+	Crawls      int
+	CrawlErrors int
+
+	// LastSuccess is when Last completed
+	LastSuccess time.Time
+
+	// Last is the most recent successful crawl, or nil if none
+	Last *crawlResult
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// foundationLabel returns a foundation label to prefix others with, only when
+// crawling several foundations
+func foundationLabel(foundation string) string {
+	if foundation == "" {
+		return ""
+	}
+	return fmt.Sprintf(`foundation="%s",`, escapeLabel(foundation))
+}
+
+// writeMetrics writes m in the Prometheus text exposition format
+func writeMetrics(out io.Writer, m *crawlMetrics) error {
+	w := bufio.NewWriter(out)
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("cf_crawls_total", "counter", "Number of crawls attempted.")
+	fmt.Fprintf(w, "cf_crawls_total %d\n", m.Crawls)
+	metric("cf_crawl_errors_total", "counter", "Number of crawls that failed.")
+	fmt.Fprintf(w, "cf_crawl_errors_total %d\n", m.CrawlErrors)
+
+	if m.Last != nil {
+		metric("cf_crawl_duration_seconds", "gauge", "How long the last successful crawl took.")
+		fmt.Fprintf(w, "cf_crawl_duration_seconds %g\n", m.Last.Duration.Seconds())
+		metric("cf_crawl_last_success_timestamp_seconds", "gauge", "When the last successful crawl completed.")
+		fmt.Fprintf(w, "cf_crawl_last_success_timestamp_seconds %d\n", m.LastSuccess.Unix())
+
+		// role assignments per org, space and role
+		assignments := make(map[string]int)
+		for _, item := range m.Last.Items {
+			assignments[foundationLabel(item.Foundation)+fmt.Sprintf(`org="%s",space="%s",role="%s"`, escapeLabel(item.Organization), escapeLabel(item.Space), escapeLabel(item.Role))]++
+		}
+		metric("cf_role_assignments", "gauge", "Number of role assignments, by org, space and role.")
+		writeCounts(w, "cf_role_assignments", assignments)
+
+		// distinct users per origin
+		seen := make(map[string]bool)
+		users := make(map[string]int)
+		for _, item := range m.Last.Items {
+			key := item.Foundation + "/" + item.UserGUID + "/" + item.Username
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			origin := item.Origin
+			if origin == "" {
+				origin = "unknown"
+			}
+			users[foundationLabel(item.Foundation)+fmt.Sprintf(`origin="%s"`, escapeLabel(origin))]++
+		}
+		metric("cf_users_total", "gauge", "Number of distinct users holding at least one role, by origin.")
+		writeCounts(w, "cf_users_total", users)
+
+		// spaces with no manager
+		managed := make(map[spaceRef]bool)
+		for _, item := range m.Last.Items {
+			if item.Role == "SpaceManager" {
+				managed[spaceRef{Foundation: item.Foundation, Organization: item.Organization, Space: item.Space}] = true
+			}
+		}
+		unmanaged := 0
+		for _, s := range m.Last.Spaces {
+			if !managed[s] {
+				unmanaged++
+			}
+		}
+		metric("cf_spaces_without_manager", "gauge", "Number of spaces with no SpaceManager.")
+		fmt.Fprintf(w, "cf_spaces_without_manager %d\n", unmanaged)
+		metric("cf_spaces", "gauge", "Number of spaces.")
+		fmt.Fprintf(w, "cf_spaces %d\n", len(m.Last.Spaces))
+	}
+
+	return w.Flush()
+}
+
+// writeCounts writes one sample per label set, sorted so that output is stable
+func writeCounts(w io.Writer, name string, counts map[string]int) {
+	var labels []string
+	for l := range counts {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, l, counts[l])
+	}
+}
+
+// writeMetricsFile writes metrics atomically, so that the node-exporter
+// textfile collector never sees a partial file, and readable by all so that
+// node-exporter can read it when running as its own user
+func writeMetricsFile(path string, m *crawlMetrics) error {
+	return writeFileAtomic(path, publicFile, func(w io.Writer) error {
+		return writeMetrics(w, m)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// expandOutputPath replaces placeholders in an output path, so that snapshots
+// from several foundations and days can sit side by side, ie
+// "report-{api-host}-{date}.json". Supported placeholders are {api-host},
+// {date} (2006-01-02) and {time} (20060102T150405Z), all in UTC.
+func expandOutputPath(path, api string, now time.Time) string {
+	host := api
+	if u, err := url.Parse(api); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	now = now.UTC()
+	return strings.NewReplacer(
+		"{api-host}", host,
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("20060102T150405Z"),
+	).Replace(path)
+}
+
+// File modes for output. Reports and archives hold the full user directory,
+// so are only readable by us, while metrics are aggregate counts that must be
+// readable by the node-exporter user.
+const (
+	privateFile os.FileMode = 0600
+	publicFile  os.FileMode = 0644
+)
+
+// writeFileAtomic calls f with a writer for a temporary file next to path,
+// then renames it into place only if f succeeds, so that a failed run never
+// leaves a truncated file. Output is compressed if path ends in .gz.
+// The file is given mode, which should be privateFile for anything holding
+// user details.
+func writeFileAtomic(path string, mode os.FileMode, f func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	err = tmp.Chmod(mode)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = writeCompressed(tmp, path, f)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checkCompression returns an error if any of paths end in a compression
+// suffix we can't handle, so that it is found before crawling rather than
+// once the report is written. zstd would need a command line tool that a cf
+// plugin can't expect to find.
+func checkCompression(paths ...string) error {
+	for _, p := range paths {
+		if strings.HasSuffix(p, ".zst") {
+			return fmt.Errorf("%s: zstd compression is not supported, use .gz instead", p)
+		}
+	}
+	return nil
+}
+
+// writeCompressed calls f with a writer to out, compressed according to the
+// suffix of path
+func writeCompressed(out io.Writer, path string, f func(io.Writer) error) error {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		zw := gzip.NewWriter(out)
+		err := f(zw)
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	default:
+		return f(out)
+	}
+}
+
+// openCompressed opens path for reading, decompressing it according to its suffix
+func openCompressed(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: zr, close: f.Close}, nil
+	default:
+		return f, nil
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc *readCloser) Close() error {
+	return rc.close()
+}
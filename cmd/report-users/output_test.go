@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandOutputPath(t *testing.T) {
+	now := time.Date(2018, 5, 6, 7, 8, 9, 0, time.FixedZone("AEST", 10*60*60))
+	got := expandOutputPath("report-{api-host}-{date}-{time}.json", "https://api.system.example.com:443", now)
+	if got != "report-api.system.example.com-2018-05-05-20180505T210809Z.json" {
+		t.Fatalf("unexpected path: %s", got)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	for _, name := range []string{"out.json", "out.json.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+
+			write := func(s string, fail bool) error {
+				return writeFileAtomic(path, privateFile, func(w io.Writer) error {
+					_, err := io.WriteString(w, s)
+					if err != nil {
+						return err
+					}
+					if fail {
+						return errors.New("failed")
+					}
+					return nil
+				})
+			}
+			read := func() string {
+				f, err := openCompressed(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				b, err := ioutil.ReadAll(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(b)
+			}
+
+			if err := write("first", false); err != nil {
+				t.Fatal(err)
+			}
+			if got := read(); got != "first" {
+				t.Fatalf("unexpected contents: %q", got)
+			}
+			if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+				t.Fatalf("expected the file to only be readable by its owner: %v %v", fi, err)
+			}
+
+			// a failed write must leave the previous file, and no temporary files
+			if err := write("second", true); err == nil {
+				t.Fatal("expected error")
+			}
+			if got := read(); got != "first" {
+				t.Fatalf("file replaced by failed write: %q", got)
+			}
+			entries, err := ioutil.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("expected only the output file to remain, got %d files", len(entries))
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMetricsFileReadable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report-users.prom")
+	err := writeMetricsFile(path, &crawlMetrics{Crawls: 1, Last: &crawlResult{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// node-exporter usually runs as its own user
+	if fi.Mode().Perm() != 0644 {
+		t.Fatalf("expected metrics to be readable by all, got %v", fi.Mode())
+	}
+}
+
+func TestZstdRejected(t *testing.T) {
+	opts := &reportOptions{OutputFormat: "json", OutputFile: "report-{date}.json.zst"}
+	err := opts.validate()
+	if err == nil || !strings.Contains(err.Error(), "zstd compression is not supported") {
+		t.Fatalf("expected .zst output to be refused before crawling, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// crawlError records a request that failed during a crawl with ContinueOnError
+type crawlError struct {
+	Foundation   string `json:"foundation,omitempty"`
+	Organization string `json:"organization"`
+	Space        string `json:"space,omitempty"`
+	URL          string `json:"url"`
+	Error        string `json:"error"`
+}
+
+// where returns the foundation, org and space the request was for, ie "prod/org-one/dev"
+func (e *crawlError) where() string {
+	var parts []string
+	for _, p := range []string{e.Foundation, e.Organization, e.Space} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// String describes the failed request, ie "org-two: GET /v2/organizations/org-2/auditors: bad status code: 404"
+func (e *crawlError) String() string {
+	if e.URL == "" {
+		return e.Error
+	}
+	return fmt.Sprintf("%s: GET %s: %s", e.where(), e.URL, e.Error)
+}
+
+// errIncomplete is returned when a report was written, but some requests failed
+var errIncomplete = errors.New("report is incomplete, as some requests failed")
+
+// exitIncomplete is the exit code for a report that was written, but is
+// incomplete. The cf CLI exits with 1 whatever a plugin exits with, so it is
+// only seen when run standalone, and plugin users rely on the report itself
+// being marked as incomplete.
+const exitIncomplete = 3
+
+// checkComplete writes a summary of any failed requests in res to w, saying
+// what may be missing from the report, ie "roles", and returns errIncomplete
+// if there were any
+func checkComplete(w io.Writer, res *crawlResult, missing string) error {
+	if len(res.Errors) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "The report is incomplete, %s may be missing from these orgs and spaces:\n", missing)
+	for _, e := range res.Errors {
+		fmt.Fprintf(w, "  %s\n", e)
+	}
+	return errIncomplete
+}
+
+// incompleteSummary says in one line how many requests failed, and what may
+// be missing as a result, ie "roles"
+func incompleteSummary(errs []*crawlError, missing string) string {
+	return fmt.Sprintf("%d requests failed or were interrupted, so some %s may be missing", len(errs), missing)
+}
+
+// writeIncompleteNote adds a note to table and markdown output if res is
+// incomplete, saying what may be missing as checkComplete does. Other formats
+// mark the report as incomplete themselves.
+func writeIncompleteNote(out io.Writer, res *crawlResult, format, missing string) {
+	if len(res.Errors) == 0 {
+		return
+	}
+	switch format {
+	case "", "table":
+		fmt.Fprintf(out, "Incomplete: %s\n", incompleteSummary(res.Errors, missing))
+	case "markdown":
+		fmt.Fprintf(out, "\n**Incomplete:** %s\n", incompleteSummary(res.Errors, missing))
+	}
+}
+
+// incompleteReport is written instead of the usual JSON array if some
+// requests failed, so that the report can't be mistaken for a complete one
+type incompleteReport struct {
+	Incomplete bool                `json:"incomplete"`
+	Errors     []*crawlError       `json:"errors"`
+	Items      []*userInfoLineItem `json:"items"`
+}
+
+// incompleteRows returns a row for each org and space with failed requests,
+// with only those and Incomplete set, so that csv output shows affected orgs
+// even where no roles were found in them. Orgs not crawled at all, as the
+// crawl was interrupted, are shown by a row with only Incomplete set.
+func incompleteRows(errs []*crawlError) []*userInfoLineItem {
+	var rv []*userInfoLineItem
+	seen := make(map[crawlError]bool)
+	for _, e := range errs {
+		k := crawlError{Foundation: e.Foundation, Organization: e.Organization, Space: e.Space}
+		if !seen[k] {
+			seen[k] = true
+			rv = append(rv, &userInfoLineItem{Foundation: e.Foundation, Organization: e.Organization, Space: e.Space, Incomplete: true})
+		}
+	}
+	return rv
+}
+
+// interruptible returns a context that is cancelled on the first interrupt,
+// or once timeout has passed if it is set, so that a partial report can be
+// written. A second interrupt exits immediately.
+func interruptible(timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout != 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			// stop catching interrupts, so that the next one exits
+			signal.Stop(sig)
+			log.Print("interrupted, writing a partial report. Interrupt again to quit immediately")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
+// exitOnError exits if err is set, with exitIncomplete if a partial report was written
+func exitOnError(err error) {
+	if err == errIncomplete {
+		log.Print(err)
+		os.Exit(exitIncomplete)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crawlProgress counts how far through a crawl we are, so that it can be
+// shown while the crawl runs. All methods are safe to call on a nil
+// *crawlProgress, which counts nothing.
+type crawlProgress struct {
+	mu    sync.Mutex
+	start time.Time
+
+	// Orgs and Spaces are the number found so far, and OrgsDone and
+	// SpacesDone the number whose roles have been fetched
+	Orgs       int
+	OrgsDone   int
+	Spaces     int
+	SpacesDone int
+
+	// Requests counts every request attempted, and Retries those that were repeats
+	Requests int
+	Retries  int
+}
+
+func newCrawlProgress() *crawlProgress {
+	return &crawlProgress{start: time.Now()}
+}
+
+func (p *crawlProgress) add(f func()) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f()
+}
+
+func (p *crawlProgress) addOrgs(n int)   { p.add(func() { p.Orgs += n }) }
+func (p *crawlProgress) orgDone()        { p.add(func() { p.OrgsDone++ }) }
+func (p *crawlProgress) addSpaces(n int) { p.add(func() { p.Spaces += n }) }
+func (p *crawlProgress) spaceDone()      { p.add(func() { p.SpacesDone++ }) }
+func (p *crawlProgress) request()        { p.add(func() { p.Requests++ }) }
+func (p *crawlProgress) retry()          { p.add(func() { p.Retries++ }) }
+
+// orgResumed counts an org and its spaces as done, by an earlier crawl
+func (p *crawlProgress) orgResumed(spaces int) {
+	p.add(func() {
+		p.OrgsDone++
+		p.Spaces += spaces
+		p.SpacesDone += spaces
+	})
+}
+
+// remaining estimates the time left, from the average time taken per org so
+// far. It returns false until there is enough to go on.
+func (p *crawlProgress) remaining(now time.Time) (time.Duration, bool) {
+	if p.OrgsDone == 0 || p.Orgs == 0 {
+		return 0, false
+	}
+	perOrg := now.Sub(p.start) / time.Duration(p.OrgsDone)
+	return perOrg * time.Duration(p.Orgs-p.OrgsDone), true
+}
+
+// summary returns a one line description of progress, ie
+// "orgs 3/10, spaces 12/40, 153 requests, 2 retries, about 1m20s remaining"
+func (p *crawlProgress) summary(now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parts := []string{
+		fmt.Sprintf("orgs %d/%d", p.OrgsDone, p.Orgs),
+		fmt.Sprintf("spaces %d/%d", p.SpacesDone, p.Spaces),
+		fmt.Sprintf("%d requests", p.Requests),
+	}
+	if p.Retries > 0 {
+		parts = append(parts, fmt.Sprintf("%d retries", p.Retries))
+	}
+	if p.OrgsDone == p.Orgs && p.Orgs > 0 {
+		parts = append(parts, fmt.Sprintf("done in %s", now.Sub(p.start).Round(time.Second)))
+	} else if eta, ok := p.remaining(now); ok {
+		parts = append(parts, fmt.Sprintf("about %s remaining", eta.Round(time.Second)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Intervals between progress updates on a terminal, and otherwise
+const (
+	terminalInterval = 250 * time.Millisecond
+	summaryInterval  = 15 * time.Second
+)
+
+// show writes progress to w until the returned function is called. On a
+// terminal a single status line is redrawn in place, otherwise a summary
+// line is written every summaryInterval, so that logs from cron or CI stay
+// readable. A final summary is always written when stopped.
+func (p *crawlProgress) show(w io.Writer, terminal bool) (stop func()) {
+	interval := summaryInterval
+	if terminal {
+		interval = terminalInterval
+	}
+
+	write := func(now time.Time, final bool) {
+		switch {
+		case !terminal:
+			fmt.Fprintf(w, "%s progress: %s\n", now.Format("2006/01/02 15:04:05"), p.summary(now))
+		case final:
+			fmt.Fprintf(w, "\r\033[K%s\n", p.summary(now))
+		default:
+			fmt.Fprintf(w, "\r\033[K%s", p.summary(now))
+		}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				write(time.Now(), true)
+				return
+			case now := <-ticker.C:
+				write(now, false)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// isTerminal returns true if f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawlProgress(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.Fail("/v2/spaces/space-1/managers", 2)
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	progress := newCrawlProgress()
+	client := fcc.client()
+	client.Progress = progress
+	res, err := (&reportUsers{}).crawlUsers(context.Background(), client, &crawlOptions{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 5 {
+		t.Fatalf("expected 5 role assignments after retries, got %d", len(res.Items))
+	}
+
+	if progress.Orgs != 1 || progress.OrgsDone != 1 || progress.Spaces != 1 || progress.SpacesDone != 1 {
+		t.Fatalf("unexpected counts: %+v", progress)
+	}
+	if progress.Retries != 2 || progress.Requests != len(fcc.Requests()) {
+		t.Fatalf("expected 2 retries and %d requests, got %d and %d", len(fcc.Requests()), progress.Retries, progress.Requests)
+	}
+	if got := progress.summary(time.Now()); !strings.HasPrefix(got, "orgs 1/1, spaces 1/1, 10 requests, 2 retries, done in") {
+		t.Fatalf("unexpected summary: %s", got)
+	}
+}
+
+func TestCrawlProgressRemaining(t *testing.T) {
+	p := newCrawlProgress()
+	p.start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.addOrgs(10)
+	if got := p.summary(p.start.Add(time.Minute)); got != "orgs 0/10, spaces 0/0, 0 requests" {
+		t.Fatalf("expected no estimate before any org is done, got: %s", got)
+	}
+	p.orgDone()
+	p.orgDone()
+	if got := p.summary(p.start.Add(time.Minute)); got != "orgs 2/10, spaces 0/0, 0 requests, about 4m0s remaining" {
+		t.Fatalf("unexpected summary: %s", got)
+	}
+}
+
+func TestGetGivesUpAfterRetries(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.Fail("/v2/organizations", maxRetries+1)
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	_, err := (&reportUsers{}).crawlUsers(context.Background(), fcc.client(), &crawlOptions{})
+	if err == nil {
+		t.Fatal("expected an error once retries are exhausted")
+	}
+	if n := len(fcc.Requests()); n != maxRetries+1 {
+		t.Fatalf("expected %d attempts, got %d", maxRetries+1, n)
+	}
+}
@@ -1,75 +1,180 @@
 package main
 
 import (
-	"crypto/tls"
+	"bufio"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/cli/plugin"
 	"github.com/olekukonko/tablewriter"
 )
 
-var insecureClient = &http.Client{
-	Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	},
-}
-
 // simpleClient is a simple CloudFoundry client
 type simpleClient struct {
 	// API url, ie "https://api.system.example.com"
 	API string
 
-	// Authorization header, ie "bearer eyXXXXX"
+	// Authorization header, ie "bearer eyXXXXX", used as-is unless conn is set
 	Authorization string
 
-	// Quiet - if set don't print progress to stderr
-	Quiet bool
+	// Verbose - if set log every request to stderr
+	Verbose bool
+
+	// Progress, if set, counts requests and retries
+	Progress *crawlProgress
+
+	// Timeout, if set, limits how long each request may take, so that a hung
+	// connection can't stall a crawl forever
+	Timeout time.Duration
 
 	// Client
 	client *http.Client
+
+	// conn, if set, is asked for a new token when Authorization is about to
+	// expire or is refused, so that long crawls outlive it. Asking the cf CLI
+	// makes it refresh the token with UAA, so it is not done for every request.
+	conn cfConnection
+
+	// mu guards Authorization and stale, when conn is set
+	mu    sync.Mutex
+	stale bool
+}
+
+// authorization returns the Authorization header to use, first fetching a
+// new token from conn if the current one was refused or expires within a minute
+func (sc *simpleClient) authorization() (string, error) {
+	if sc.conn == nil {
+		return sc.Authorization, nil
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	expiry := jwtExpiry(sc.Authorization)
+	if sc.stale || (!expiry.IsZero() && time.Now().Add(time.Minute).After(expiry)) {
+		at, err := sc.conn.AccessToken()
+		if err != nil {
+			return "", err
+		}
+		sc.Authorization, sc.stale = at, false
+	}
+	return sc.Authorization, nil
+}
+
+// refused marks authorization as stale after a 401, unless it has already
+// been replaced by another request
+func (sc *simpleClient) refused(authorization string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.Authorization == authorization {
+		sc.stale = true
+	}
+}
+
+// url returns the absolute URL for r, which is relative to the API unless it is already absolute
+func (sc *simpleClient) url(r string) string {
+	if strings.HasPrefix(r, "https://") || strings.HasPrefix(r, "http://") {
+		return r
+	}
+	return sc.API + r
+}
+
+// maxRetries is how many times a request that failed with a network error,
+// rate limit or server error is retried before giving up
+const maxRetries = 3
+
+// retryDelay is how long to wait before the first retry, doubling each time
+var retryDelay = time.Second
+
+// Get makes a GET request, where r is the relative path (or an absolute URL, for
+// other services such as UAA), and rv is json.Unmarshalled to
+func (sc *simpleClient) Get(ctx context.Context, r string, rv interface{}) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := sc.get(ctx, r, rv)
+		if !retry || attempt == maxRetries || ctx.Err() != nil {
+			return err
+		}
+		sc.Progress.retry()
+		if sc.Verbose {
+			log.Printf("retrying in %s: %s", delay, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
 }
 
-// Get makes a GET request, where r is the relative path, and rv is json.Unmarshalled to
-func (sc *simpleClient) Get(r string, rv interface{}) error {
-	if !sc.Quiet {
-		log.Printf("GET %s%s", sc.API, r)
+// get makes a single attempt at Get, and returns true if a failure is worth retrying
+func (sc *simpleClient) get(ctx context.Context, r string, rv interface{}) (bool, error) {
+	if sc.Verbose {
+		log.Printf("GET %s", sc.url(r))
 	}
-	req, err := http.NewRequest(http.MethodGet, sc.API+r, nil)
+	if sc.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sc.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sc.url(r), nil)
 	if err != nil {
-		return err
+		return false, err
 	}
-	req.Header.Set("Authorization", sc.Authorization)
+	authorization, err := sc.authorization()
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", authorization)
+	sc.Progress.request()
 	resp, err := sc.client.Do(req)
 	if err != nil {
-		return err
+		return true, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true, fmt.Errorf("bad status code: %d", resp.StatusCode)
+	}
+	// the token may have been revoked, or expired before we expected, so
+	// retry with a new one
+	if resp.StatusCode == http.StatusUnauthorized && sc.conn != nil {
+		sc.refused(authorization)
+		return true, fmt.Errorf("bad status code: %d", resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return errors.New("bad status code")
+		return false, fmt.Errorf("bad status code: %d", resp.StatusCode)
 	}
 
-	return json.NewDecoder(resp.Body).Decode(rv)
+	return false, json.NewDecoder(resp.Body).Decode(rv)
 }
 
 // List makes a GET request, to list resources, where we will follow the "next_url"
-// to page results, and calls "f" as a callback to process each resource found
-func (sc *simpleClient) List(r string, f func(*resource) error) error {
+// (v2) or "pagination.next.href" (v3) to page results, and calls "f" as a callback
+// to process each resource found
+func (sc *simpleClient) List(ctx context.Context, r string, f func(*resource) error) error {
 	for r != "" {
 		var res struct {
-			NextURL   string `json:"next_url"`
+			NextURL    string `json:"next_url"`
+			Pagination struct {
+				Next *struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			} `json:"pagination"`
 			Resources []*resource
 		}
-		err := sc.Get(r, &res)
+		err := sc.Get(ctx, r, &res)
 		if err != nil {
 			return err
 		}
@@ -82,17 +187,62 @@ func (sc *simpleClient) List(r string, f func(*resource) error) error {
 		}
 
 		r = res.NextURL
+		if res.Pagination.Next != nil {
+			// v3 gives us an absolute URL, we want just the path and query
+			u, err := url.Parse(res.Pagination.Next.Href)
+			if err != nil {
+				return err
+			}
+			r = u.RequestURI()
+		}
 	}
 	return nil
 }
 
+// ccClient is what a report needs to fetch data from the Cloud Controller
+type ccClient interface {
+	// Get makes a GET request, where r is the relative path (or an absolute URL, for
+	// other services such as UAA), and rv is json.Unmarshalled to
+	Get(ctx context.Context, r string, rv interface{}) error
+
+	// List makes a GET request to list resources, following all pages, and calls f for each
+	List(ctx context.Context, r string, f func(*resource) error) error
+}
+
+// cfConnection is the part of plugin.CliConnection needed to construct a client
+type cfConnection interface {
+	ApiEndpoint() (string, error)
+	AccessToken() (string, error)
+	IsSSLDisabled() (bool, error)
+}
+
 // resource captures fields that we care about when
-// retrieving data from CloudFoundry
+// retrieving data from CloudFoundry. v2 resources populate Metadata
+// and Entity, v3 resources populate the top-level fields.
 type resource struct {
+	GUID   string `json:"guid"`   // v3
+	Name   string `json:"name"`   // v3
+	Origin string `json:"origin"` // v3 user
+
+	CreatedAt    time.Time   `json:"created_at"`   // v3
+	Type         string      `json:"type"`         // audit event
+	Actor        eventActor  `json:"actor"`        // audit event
+	Target       eventActor  `json:"target"`       // audit event
+	Space        relatedGUID `json:"space"`        // audit event
+	Organization relatedGUID `json:"organization"` // audit event
+
 	Metadata struct {
 		GUID      string    `json:"guid"`       // app
 		UpdatedAt time.Time `json:"updated_at"` // buildpack
+
+		Labels      map[string]string `json:"labels"`      // v3
+		Annotations map[string]string `json:"annotations"` // v3
 	} `json:"metadata"`
+	Relationships struct {
+		Organization relationship `json:"organization"` // v3 space, role
+		Space        relationship `json:"space"`        // v3 role
+		User         relationship `json:"user"`         // v3 role
+	} `json:"relationships"`
 	Entity struct {
 		Name               string    // org, space
 		SpacesURL          string    `json:"spaces_url"`              // org
@@ -109,6 +259,14 @@ type resource struct {
 		Filename           string    `json:"filename"`           // buildpack
 		Enabled            bool      `json:"enabled"`            // buildpack
 		PackageUpdatedAt   time.Time `json:"package_updated_at"` // app
+
+		QuotaGUID          string `json:"quota_definition_guid"`          // org
+		DefaultSegmentGUID string `json:"default_isolation_segment_guid"` // org
+
+		AllowSSH                 bool           `json:"allow_ssh"`                   // space
+		SecurityGroupsURL        string         `json:"security_groups_url"`         // space
+		StagingSecurityGroupsURL string         `json:"staging_security_groups_url"` // space
+		Rules                    []securityRule `json:"rules"`                       // security group
 	} `json:"entity"`
 }
 
@@ -122,7 +280,7 @@ type droplet struct {
 
 type reportUsers struct{}
 
-func newSimpleClient(cliConnection plugin.CliConnection, quiet, insecureSkipVerify bool) (*simpleClient, error) {
+func newSimpleClient(cliConnection cfConnection, verbose bool, client *http.Client) (*simpleClient, error) {
 	at, err := cliConnection.AccessToken()
 	if err != nil {
 		return nil, err
@@ -133,125 +291,824 @@ func newSimpleClient(cliConnection plugin.CliConnection, quiet, insecureSkipVeri
 		return nil, err
 	}
 
-	client := http.DefaultClient
-	if insecureSkipVerify {
-		client = insecureClient
-	}
-
 	return &simpleClient{
 		API:           api,
 		Authorization: at,
-		Quiet:         quiet,
+		Verbose:       verbose,
 		client:        client,
+		conn:          cliConnection,
 	}, nil
 }
 
-func (c *reportUsers) Run(cliConnection plugin.CliConnection, args []string) {
-	outputJSON := false
-	quiet := false
-	orgUsers := false
-	insecureSkipVerify := false
-
-	fs := flag.NewFlagSet("report-users", flag.ExitOnError)
-	fs.BoolVar(&outputJSON, "output-json", false, "if set sends JSON to stdout instead of a rendered table")
-	fs.BoolVar(&quiet, "quiet", false, "if set suppressing printing of progress messages to stderr")
-	fs.BoolVar(&orgUsers, "org-users", false, "if set include org-users which are otherwise skipped")
-	fs.BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "if set disables TLS verification")
-	err := fs.Parse(args[1:])
+// reportOptions are the flags shared by the plugin and standalone modes
+type reportOptions struct {
+	OutputJSON         bool
+	OutputFormat       string
+	OutputFile         string
+	Quiet              bool
+	Verbose            bool
+	ContinueOnError    bool
+	OrgUsers           bool
+	InsecureSkipVerify bool
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	Proxy              string
+	MaxIdleConns       int
+	TraceHTTP          bool
+	TraceFile          string
+	SaveArchive        string
+	FromArchive        string
+	Listen             string
+	ServeToken         string
+	Interval           time.Duration
+	MetricsFile        string
+	Checkpoint         string
+	Timeout            time.Duration
+	RequestTimeout     time.Duration
+	Since              string
+	Orgs               string
+	Spaces             string
+	Usernames          string
+	Roles              string
+	IsolationSegments  string
+	OrgSelector        string
+	SpaceSelector      string
+	GroupBy            string
+	Columns            string
+	Sort               string
+	NoHeader           bool
+	Foundations        string
+	CrossFoundation    bool
+}
+
+// outputFormats are the valid values for --output-format
+var outputFormats = []string{"table", "json", "csv", "xlsx", "html", "markdown", "dot", "graphml"}
+
+// format returns the output format, taking into account the older --output-json flag
+func (o *reportOptions) format() string {
+	if o.OutputJSON {
+		return "json"
+	}
+	return o.OutputFormat
+}
+
+// validate checks for invalid combinations of options, before we do any work
+func (o *reportOptions) validate() error {
+	if !matchesAny(outputFormats, o.format()) {
+		return fmt.Errorf("unknown output format: %s", o.format())
+	}
+	if o.format() == "xlsx" && o.OutputFile == "" {
+		return errors.New("--output-file must be set for xlsx output")
+	}
+	if o.Foundations != "" && (o.SaveArchive != "" || o.FromArchive != "") {
+		return errors.New("archives can't be used with --foundations")
+	}
+	if o.Checkpoint != "" && o.Foundations != "" {
+		return errors.New("--checkpoint can't be used with --foundations")
+	}
+	if o.TraceFile != "" && o.Foundations != "" {
+		return errors.New("--trace-file can't be used with --foundations")
+	}
+	if o.CrossFoundation && o.Foundations == "" {
+		return errors.New("--cross-foundation needs --foundations")
+	}
+	if o.CrossFoundation && !matchesAny([]string{"table", "json", "csv", "markdown"}, o.format()) {
+		return fmt.Errorf("--cross-foundation does not support %s output", o.format())
+	}
+	if o.GroupBy != "" && o.GroupBy != "org" {
+		return fmt.Errorf("unknown --group-by: %s", o.GroupBy)
+	}
+	err := checkCompression(o.OutputFile, o.SaveArchive, o.FromArchive, o.MetricsFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	_, err = o.columns()
+	if err != nil {
+		return err
+	}
+	_, err = parseSort(o.Sort)
+	return err
+}
+
+// usedColumns returns the columns needed for output and sorting
+func (o *reportOptions) usedColumns() ([]*column, error) {
+	cols, err := o.columns()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseSort(o.Sort)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		cols = append(cols, k.Column)
+	}
+	return cols, nil
+}
+
+// columns returns the columns selected by --columns
+func (o *reportOptions) columns() ([]*column, error) {
+	cols := o.Columns
+	if cols == "" {
+		cols = defaultColumns
+	}
+	if cols == defaultColumns && o.Foundations != "" {
+		cols = "foundation," + cols
+	}
+	if cols == defaultColumns && o.ContinueOnError {
+		cols += ",incomplete"
+	}
+	return parseColumns(cols)
+}
+
+// httpClient returns a client with the transport options set by flags. TLS verification
+// is also disabled if sslDisabled, ie if "cf login --skip-ssl-validation" was used.
+func (o *reportOptions) httpClient(sslDisabled bool) (*http.Client, error) {
+	t := o.transport()
+	t.InsecureSkipVerify = t.InsecureSkipVerify || sslDisabled
+	return t.httpClient()
+}
+
+// transport returns the transport options set by flags
+func (o *reportOptions) transport() *transportOptions {
+	return &transportOptions{
+		InsecureSkipVerify:  o.InsecureSkipVerify,
+		CACert:              o.CACert,
+		ClientCert:          o.ClientCert,
+		ClientKey:           o.ClientKey,
+		Proxy:               o.Proxy,
+		MaxIdleConnsPerHost: o.MaxIdleConns,
+		Trace:               o.TraceHTTP,
+	}
+}
+
+// progress returns a counter for crawl progress, or nil if it won't be shown
+func (o *reportOptions) progress() *crawlProgress {
+	if o.Quiet {
+		return nil
+	}
+	return newCrawlProgress()
+}
+
+// crawlOptions returns the options for a crawl set by flags. Progress and
+// Checkpoint are left to the caller.
+func (o *reportOptions) crawlOptions() *crawlOptions {
+	return &crawlOptions{
+		IncludeOrgUsers: o.OrgUsers,
+		ContinueOnError: o.ContinueOnError,
+	}
+}
+
+// showProgress shows progress on stderr until the returned function is called.
+// A status line is redrawn in place on a terminal, unless that would be
+// interleaved with --verbose or --trace-http logging.
+func (o *reportOptions) showProgress(progress *crawlProgress) (stop func()) {
+	if progress == nil {
+		return func() {}
+	}
+	return progress.show(os.Stderr, isTerminal(os.Stderr) && !o.Verbose && !o.TraceHTTP)
+}
+
+// splitList splits a comma separated flag value, ignoring empty entries
+func splitList(s string) []string {
+	var rv []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			rv = append(rv, v)
+		}
 	}
+	return rv
+}
+
+// filter returns the filter selected by the --org, --space, --username, --role and --isolation-segment flags
+func (o *reportOptions) filter() *roleFilter {
+	return &roleFilter{
+		Organizations: splitList(o.Orgs),
+		Spaces:        splitList(o.Spaces),
+		Usernames:     splitList(o.Usernames),
+		Roles:         splitList(o.Roles),
+		Segments:      splitList(o.IsolationSegments),
+	}
+}
+
+// register adds flags for all options to fs
+func (o *reportOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.OutputJSON, "output-json", false, "if set sends JSON to stdout instead of a rendered table, same as --output-format json")
+	fs.StringVar(&o.OutputFormat, "output-format", "table", "output format, one of: "+strings.Join(outputFormats, ", "))
+	fs.StringVar(&o.OutputFile, "output-file", "", "if set writes output to this file instead of stdout, replacing it only on success. Compressed if ending in .gz, and {api-host}, {date} and {time} are expanded")
+	fs.BoolVar(&o.Quiet, "quiet", false, "if set suppressing printing of progress messages to stderr")
+	fs.BoolVar(&o.Verbose, "verbose", false, "if set logs every API request to stderr")
+	fs.BoolVar(&o.OrgUsers, "org-users", false, "if set include org-users which are otherwise skipped")
+	fs.BoolVar(&o.ContinueOnError, "continue-on-error", false, "if set, failed requests are recorded and the crawl carries on, rather than aborting. Affected rows are marked incomplete, and the exit code is 3")
+	fs.BoolVar(&o.InsecureSkipVerify, "insecure-skip-verify", false, "if set disables TLS verification")
+	fs.StringVar(&o.CACert, "ca-cert", "", "if set trusts the CAs in this PEM file, as well as the system ones")
+	fs.StringVar(&o.ClientCert, "client-cert", "", "if set presents the client certificate in this PEM file, ie for an mTLS proxy")
+	fs.StringVar(&o.ClientKey, "client-key", "", "private key for --client-cert, in PEM format")
+	fs.StringVar(&o.Proxy, "proxy", "", "if set sends all requests through this proxy, ie http://proxy.example.com:3128, instead of using $HTTPS_PROXY and $NO_PROXY")
+	fs.IntVar(&o.MaxIdleConns, "max-idle-conns", 16, "how many keep-alive connections to keep open to each host")
+	fs.StringVar(&o.TraceFile, "trace-file", "", "if set writes every request, with its status, timing and response body, to this file as JSON lines, with tokens and emails redacted")
+	fs.BoolVar(&o.TraceHTTP, "trace-http", false, "if set logs the headers of every request and response to stderr, with credentials redacted")
+	fs.StringVar(&o.SaveArchive, "save-archive", "", "if set saves all raw API responses to this file, for later use with -from-archive")
+	fs.StringVar(&o.FromArchive, "from-archive", "", "if set reads API responses from this archive file instead of CloudFoundry")
+	fs.StringVar(&o.Listen, "listen", "127.0.0.1:8080", "serve only: address to listen on, which must be a loopback address unless --serve-token is set")
+	fs.StringVar(&o.ServeToken, "serve-token", "", "serve only: if set, requests must give this token as a bearer token or basic auth password (default $REPORT_USERS_SERVE_TOKEN)")
+	fs.DurationVar(&o.Interval, "interval", time.Hour, "serve only: how often to crawl CloudFoundry")
+	fs.StringVar(&o.Orgs, "org", "", "if set only report on these orgs, comma separated")
+	fs.StringVar(&o.Spaces, "space", "", "if set only report on these spaces, comma separated")
+	fs.StringVar(&o.Usernames, "username", "", "if set only report on these users, comma separated")
+	fs.StringVar(&o.Roles, "role", "", "if set only report on these roles, comma separated, ie SpaceDeveloper,SpaceManager")
+	fs.StringVar(&o.IsolationSegments, "isolation-segment", "", "if set only report on orgs entitled to these isolation segments, comma separated")
+	fs.StringVar(&o.OrgSelector, "org-selector", "", "if set only crawls orgs matching this label selector, ie env=prod")
+	fs.StringVar(&o.SpaceSelector, "space-selector", "", "if set only crawls spaces matching this label selector, and the orgs containing them")
+	fs.StringVar(&o.GroupBy, "group-by", "", "if set to \"org\", groups table and markdown output by org")
+	fs.StringVar(&o.Columns, "columns", defaultColumns, "columns for table, csv and markdown output, comma separated, from: "+strings.Join(columnNames(), ", ")+", or a label or annotation, ie org_label:cost-centre or space_annotation:owner")
+	fs.StringVar(&o.Sort, "sort", "", "if set sorts by these columns, comma separated, prefix with - for descending, ie organization,-last_logon")
+	fs.BoolVar(&o.NoHeader, "no-header", false, "if set omits the header row from table and csv output")
+	fs.StringVar(&o.Foundations, "foundations", "", "if set crawls every foundation listed in this JSON config file, instead of the current one")
+	fs.BoolVar(&o.CrossFoundation, "cross-foundation", false, "if set with --foundations, reports only users with access to more than one foundation")
+	fs.StringVar(&o.Checkpoint, "checkpoint", "", "if set records each org in this file as it is crawled, so that an interrupted run can be resumed by rerunning with the same file")
+	fs.DurationVar(&o.Timeout, "timeout", 0, "if set, stops crawling after this long, and writes a partial report marked incomplete")
+	fs.DurationVar(&o.RequestTimeout, "request-timeout", 2*time.Minute, "how long a single API request may take before it is retried")
+	fs.StringVar(&o.Since, "since", "30d", "report-role-events only: how far back to report, as a duration such as 30d or 12h, or a date such as 2024-01-31")
+	fs.StringVar(&o.MetricsFile, "metrics-file", "", "if set writes Prometheus metrics to this file, in node-exporter textfile format")
+}
 
-	client, err := newSimpleClient(cliConnection, quiet, insecureSkipVerify)
+func (c *reportUsers) Run(cliConnection plugin.CliConnection, args []string) {
+	var opts reportOptions
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	opts.register(fs)
+	err := fs.Parse(args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	switch args[0] {
+	exitOnError(c.run(cliConnection, args[0], &opts))
+}
+
+// run executes command, using conn to talk to CloudFoundry unless reading from an archive
+func (c *reportUsers) run(conn cfConnection, command string, opts *reportOptions) error {
+	switch command {
 	case "report-users":
-		err := c.reportUsers(client, os.Stdout, outputJSON, orgUsers)
+		err := opts.validate()
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		ctx, cancel := interruptible(opts.Timeout)
+		defer cancel()
+		if opts.Foundations != "" {
+			return c.reportFoundations(ctx, opts)
+		}
+		client, archive, closeTrace, err := c.newTracedClient(conn, opts)
+		if err != nil {
+			return err
 		}
+		defer closeTrace()
+		co := opts.crawlOptions()
+		co.Progress = opts.progress()
+		client.Progress = co.Progress
+		if opts.Checkpoint != "" {
+			co.Checkpoint, err = openCheckpoint(opts.Checkpoint, &checkpointHeader{
+				API:             client.API,
+				IncludeOrgUsers: co.IncludeOrgUsers,
+				OrgSelector:     opts.OrgSelector,
+				SpaceSelector:   opts.SpaceSelector,
+			})
+			if err != nil {
+				return err
+			}
+			defer co.Checkpoint.Close()
+		}
+		now := time.Now()
+		var res *crawlResult
+		err = writeOutput(expandOutputPath(opts.OutputFile, client.API, now), func(out io.Writer) error {
+			var err error
+			res, err = c.reportUsers(ctx, client, out, opts, co)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(res.Errors) == 0 {
+			// finished, so the next run should start afresh
+			err = co.Checkpoint.remove()
+			if err != nil {
+				return err
+			}
+		}
+		if opts.SaveArchive != "" {
+			err = archive.save(expandOutputPath(opts.SaveArchive, client.API, now))
+			if err != nil {
+				return err
+			}
+		}
+		return checkComplete(os.Stderr, res, "roles")
+	case "report-space-security":
+		return c.reportSpaceSecurity(conn, opts)
+	case "report-role-events":
+		return c.reportRoleEvents(conn, opts)
+	case "serve":
+		return c.serve(conn, opts)
+	default:
+		return fmt.Errorf("unknown command: %s", command)
 	}
 }
 
+// newClient returns a client as specified by opts. If an archive is being read
+// or saved, it is also returned.
+func (c *reportUsers) newClient(conn cfConnection, opts *reportOptions) (*simpleClient, *crawlArchive, error) {
+	if opts.FromArchive != "" {
+		archive, err := loadCrawlArchive(opts.FromArchive)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newArchiveClient(archive, opts.Verbose), archive, nil
+	}
+
+	sslDisabled, err := conn.IsSSLDisabled()
+	if err != nil {
+		return nil, nil, err
+	}
+	httpClient, err := opts.httpClient(sslDisabled)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := newSimpleClient(conn, opts.Verbose, httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	client.Timeout = opts.RequestTimeout
+	var archive *crawlArchive
+	if opts.SaveArchive != "" {
+		archive = newCrawlArchive(client.API)
+		client.recordTo(archive)
+	}
+	return client, archive, nil
+}
+
+// newTracedClient returns a client as newClient does, which also writes to
+// --trace-file if set. closeTrace must be called once the client is finished with.
+func (c *reportUsers) newTracedClient(conn cfConnection, opts *reportOptions) (client *simpleClient, archive *crawlArchive, closeTrace func() error, err error) {
+	client, archive, err = c.newClient(conn, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if opts.TraceFile == "" {
+		return client, archive, func() error { return nil }, nil
+	}
+	trace, err := createTraceFile(opts.TraceFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	client.traceTo(trace)
+	return client, archive, trace.Close, nil
+}
+
 type userInfoLineItem struct {
-	Organization string `json:"organization"`
-	Space        string `json:"space,omitempty"`
-	Username     string `json:"username"`
-	Role         string `json:"role"`
+	Organization string     `json:"organization"`
+	OrgGUID      string     `json:"org_guid,omitempty"`
+	Space        string     `json:"space,omitempty"`
+	SpaceGUID    string     `json:"space_guid,omitempty"`
+	Username     string     `json:"username"`
+	Role         string     `json:"role"`
+	Foundation   string     `json:"foundation,omitempty"`
+	UserGUID     string     `json:"user_guid,omitempty"`
+	Origin       string     `json:"origin,omitempty"`
+	Email        string     `json:"email,omitempty"`
+	LastLogon    *time.Time `json:"last_logon,omitempty"`
+
+	// GrantedAt is when the role was granted, and GrantedBy who by, set only if needed
+	GrantedAt *time.Time `json:"granted_at,omitempty"`
+	GrantedBy string     `json:"granted_by,omitempty"`
+
+	// IsolationSegments the org is entitled to, its DefaultIsolationSegment and
+	// Quota name, set only if needed
+	IsolationSegments       []string `json:"isolation_segments,omitempty"`
+	DefaultIsolationSegment string   `json:"default_isolation_segment,omitempty"`
+	Quota                   string   `json:"quota,omitempty"`
+
+	// Labels and annotations of the org and space, set only if needed
+	OrgLabels        map[string]string `json:"org_labels,omitempty"`
+	OrgAnnotations   map[string]string `json:"org_annotations,omitempty"`
+	SpaceLabels      map[string]string `json:"space_labels,omitempty"`
+	SpaceAnnotations map[string]string `json:"space_annotations,omitempty"`
+
+	// Incomplete is set if some requests for this org failed, so it may be missing roles
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// spaceRef identifies a space visited during a crawl
+type spaceRef struct {
+	Foundation   string
+	Organization string
+	Space        string
+}
+
+// crawlResult is everything found by one crawl
+type crawlResult struct {
+	// Items has a line item for every role assignment found
+	Items []*userInfoLineItem
+
+	// Spaces lists every space visited, whether or not it has any users
+	Spaces []spaceRef
+
+	// Errors lists requests that failed, if crawling with ContinueOnError
+	Errors []*crawlError
+
+	// Duration is how long the crawl took
+	Duration time.Duration
+}
+
+// reportUsers crawls all users as specified by co, and writes the report
+// specified by opts to out. If co.Progress is set, it is shown while crawling.
+// The crawl result is returned, so that any failed requests can be reported.
+// If ctx is done part way through, whatever has been collected so far is written.
+func (c *reportUsers) reportUsers(ctx context.Context, client ccClient, out io.Writer, opts *reportOptions, co *crawlOptions) (*crawlResult, error) {
+	stop := opts.showProgress(co.Progress)
+	res, err := c.collectUsers(ctx, client, opts, co)
+	stop()
+	if err != nil {
+		return nil, err
+	}
+	return res, c.writeResult(out, res, opts)
+}
+
+// collectUsers crawls all users, and then looks up any extra user details needed by opts
+func (c *reportUsers) collectUsers(ctx context.Context, client ccClient, opts *reportOptions, co *crawlOptions) (*crawlResult, error) {
+	err := applySelectors(ctx, client, opts, co)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.crawlUsers(ctx, client, co)
+	if err != nil {
+		return nil, err
+	}
+
+	// only look up user details if they will be used
+	cols, err := opts.usedColumns()
+	if err != nil {
+		return nil, err
+	}
+	if opts.MetricsFile != "" || hasColumn(cols, "origin") {
+		lookupOrigins(ctx, client, res.Items)
+	}
+	if hasColumn(cols, "email", "last_logon") {
+		lookupUAADetails(ctx, client, res.Items)
+	}
+	if hasColumn(cols, "isolation_segments", "default_isolation_segment", "quota") || opts.IsolationSegments != "" {
+		err = lookupOrgContext(ctx, client, res.Items)
+		if err != nil {
+			// the columns can be left empty, but the filter can't be applied without them
+			if opts.IsolationSegments != "" {
+				return nil, err
+			}
+			log.Print(err)
+		}
+	}
+	if hasMetadataColumn(cols) {
+		lookupMetadata(ctx, client, res.Items)
+	}
+	if hasColumn(cols, "granted_at", "granted_by") {
+		lookupGrants(ctx, client, res.Items)
+	}
+	return res, nil
 }
 
-func (c *reportUsers) reportUsers(client *simpleClient, out io.Writer, outputJSON, includeOrgUsers bool) error {
-	var allInfo []*userInfoLineItem
-	err := client.List("/v2/organizations", func(org *resource) error {
-		for _, orgRole := range []struct {
+// writeResult writes metrics if requested, and then the report specified by opts to out
+func (c *reportUsers) writeResult(out io.Writer, res *crawlResult, opts *reportOptions) error {
+	if opts.MetricsFile != "" {
+		err := writeMetricsFile(opts.MetricsFile, &crawlMetrics{
+			Crawls:      1,
+			LastSuccess: time.Now(),
+			Last:        res,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	keys, err := parseSort(opts.Sort)
+	if err != nil {
+		return err
+	}
+	items := opts.filter().apply(res.Items)
+	sortItems(items, keys)
+	if opts.CrossFoundation {
+		return writeCrossFoundationReport(out, items, opts)
+	}
+	if len(res.Errors) != 0 && !opts.ContinueOnError {
+		// interrupted, so show which rows are affected as --continue-on-error would
+		withIncomplete := *opts
+		withIncomplete.ContinueOnError = true
+		opts = &withIncomplete
+	}
+	err = writeReport(out, items, res.Errors, opts)
+	if err != nil {
+		return err
+	}
+	writeIncompleteNote(out, res, opts.format(), "roles")
+	return nil
+}
+
+// crawlOptions controls what a crawl collects, and what happens when requests fail
+type crawlOptions struct {
+	// IncludeOrgUsers adds the OrgUser role, which is otherwise skipped
+	IncludeOrgUsers bool
+
+	// ContinueOnError records failed requests within an org and carries on,
+	// rather than aborting the crawl
+	ContinueOnError bool
+
+	// Progress, if set, counts orgs and spaces as they are crawled
+	Progress *crawlProgress
+
+	// Checkpoint, if set, records each org as it is completed, and supplies
+	// orgs completed by an earlier crawl
+	Checkpoint *crawlCheckpoint
+
+	// Orgs and Spaces, if set, are the GUIDs of the only orgs and spaces to crawl
+	Orgs   map[string]bool
+	Spaces map[string]bool
+}
+
+// crawlUsers walks all orgs and spaces, and returns a line item for every role
+// assignment found. If ctx is done part way through, the orgs crawled so far
+// are returned, with an error recorded for the rest.
+func (c *reportUsers) crawlUsers(ctx context.Context, client ccClient, co *crawlOptions) (*crawlResult, error) {
+	start := time.Now()
+
+	// list all orgs first, so that we know how far through we are
+	var orgs []*resource
+	err := client.List(ctx, "/v2/organizations", func(org *resource) error {
+		if co.Orgs == nil || co.Orgs[org.Metadata.GUID] {
+			orgs = append(orgs, org)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	co.Progress.addOrgs(len(orgs))
+
+	rv := &crawlResult{}
+	for i, org := range orgs {
+		if ctx.Err() != nil {
+			rv.Errors = append(rv.Errors, &crawlError{
+				Error: fmt.Sprintf("%s, %d of %d orgs not crawled", ctx.Err(), len(orgs)-i, len(orgs)),
+			})
+			break
+		}
+		if res, ok := co.Checkpoint.done(org.Metadata.GUID); ok {
+			rv.Items = append(rv.Items, res.Items...)
+			rv.Spaces = append(rv.Spaces, res.Spaces...)
+			co.Progress.orgResumed(len(res.Spaces))
+			continue
+		}
+		res, err := c.crawlOrg(ctx, client, org, co)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if err == nil && len(res.Errors) == 0 {
+			err = co.Checkpoint.add(org.Metadata.GUID, res)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(res.Errors) != 0 {
+			for _, item := range res.Items {
+				item.Incomplete = true
+			}
+		}
+		rv.Items = append(rv.Items, res.Items...)
+		rv.Spaces = append(rv.Spaces, res.Spaces...)
+		rv.Errors = append(rv.Errors, res.Errors...)
+		co.Progress.orgDone()
+	}
+	rv.Duration = time.Since(start)
+	return rv, nil
+}
+
+// crawlOrg returns a line item for every role assignment in org and its
+// spaces, and the spaces visited. With ContinueOnError, failed requests are
+// added to Errors rather than returned. If ctx is done, the partial result is
+// returned along with the error.
+func (c *reportUsers) crawlOrg(ctx context.Context, client ccClient, org *resource, co *crawlOptions) (*crawlResult, error) {
+	rv := &crawlResult{}
+	fail := func(space, r string, err error) error {
+		if ctx.Err() != nil {
+			rv.Errors = append(rv.Errors, &crawlError{
+				Organization: org.Entity.Name,
+				Space:        space,
+				URL:          r,
+				Error:        ctx.Err().Error(),
+			})
+			return ctx.Err()
+		}
+		if !co.ContinueOnError {
+			return err
+		}
+		rv.Errors = append(rv.Errors, &crawlError{
+			Organization: org.Entity.Name,
+			Space:        space,
+			URL:          r,
+			Error:        err.Error(),
+		})
+		return nil
+	}
+
+	for _, orgRole := range []struct {
+		Role string
+		URL  string
+		Do   bool
+	}{
+		{"OrgUser", org.Entity.UsersURL, co.IncludeOrgUsers}, // We used to think these don't appear to be terribly meaningful, so they are optional
+		{"OrgManager", org.Entity.ManagersURL, true},
+		{"OrgBillingManager", org.Entity.BillingManagersURL, true},
+		{"OrgAuditor", org.Entity.AuditorsURL, true},
+	} {
+		if !orgRole.Do {
+			continue
+		}
+		err := client.List(ctx, orgRole.URL, func(user *resource) error {
+			rv.Items = append(rv.Items, &userInfoLineItem{
+				Organization: org.Entity.Name,
+				OrgGUID:      org.Metadata.GUID,
+				Username:     user.Entity.Username,
+				Role:         orgRole.Role,
+				UserGUID:     user.Metadata.GUID,
+			})
+			return nil
+		})
+		if err != nil {
+			err = fail("", orgRole.URL, err)
+			if err != nil {
+				return rv, err
+			}
+		}
+	}
+
+	var spaces []*resource
+	err := client.List(ctx, org.Entity.SpacesURL, func(space *resource) error {
+		if co.Spaces == nil || co.Spaces[space.Metadata.GUID] {
+			spaces = append(spaces, space)
+		}
+		return nil
+	})
+	if err != nil {
+		err = fail("", org.Entity.SpacesURL, err)
+		if err != nil {
+			return rv, err
+		}
+	}
+	co.Progress.addSpaces(len(spaces))
+
+	for _, space := range spaces {
+		rv.Spaces = append(rv.Spaces, spaceRef{Organization: org.Entity.Name, Space: space.Entity.Name})
+		for _, spaceRole := range []struct {
 			Role string
 			URL  string
-			Do   bool
 		}{
-			{"OrgUser", org.Entity.UsersURL, includeOrgUsers}, // We used to think these don't appear to be terribly meaningful, so they are optional
-			{"OrgManager", org.Entity.ManagersURL, true},
-			{"OrgBillingManager", org.Entity.BillingManagersURL, true},
-			{"OrgAuditor", org.Entity.AuditorsURL, true},
+			{"SpaceDeveloper", space.Entity.DevelopersURL},
+			{"SpaceManager", space.Entity.ManagersURL},
+			{"SpaceAuditor", space.Entity.AuditorsURL},
 		} {
-			if !orgRole.Do {
-				continue
-			}
-			err := client.List(orgRole.URL, func(user *resource) error {
-				allInfo = append(allInfo, &userInfoLineItem{
+			err := client.List(ctx, spaceRole.URL, func(user *resource) error {
+				rv.Items = append(rv.Items, &userInfoLineItem{
 					Organization: org.Entity.Name,
+					OrgGUID:      org.Metadata.GUID,
+					Space:        space.Entity.Name,
+					SpaceGUID:    space.Metadata.GUID,
 					Username:     user.Entity.Username,
-					Role:         orgRole.Role,
+					Role:         spaceRole.Role,
+					UserGUID:     user.Metadata.GUID,
 				})
 				return nil
 			})
 			if err != nil {
-				return err
-			}
-		}
-
-		return client.List(org.Entity.SpacesURL, func(space *resource) error {
-			for _, spaceRole := range []struct {
-				Role string
-				URL  string
-			}{
-				{"SpaceDeveloper", space.Entity.DevelopersURL},
-				{"SpaceManager", space.Entity.ManagersURL},
-				{"SpaceAuditor", space.Entity.AuditorsURL},
-			} {
-				err := client.List(spaceRole.URL, func(user *resource) error {
-					allInfo = append(allInfo, &userInfoLineItem{
-						Organization: org.Entity.Name,
-						Space:        space.Entity.Name,
-						Username:     user.Entity.Username,
-						Role:         spaceRole.Role,
-					})
-					return nil
-				})
+				err = fail(space.Entity.Name, spaceRole.URL, err)
 				if err != nil {
-					return err
+					return rv, err
 				}
 			}
-			return nil
-		})
-	})
+		}
+		co.Progress.spaceDone()
+	}
+	return rv, nil
+}
+
+// writeOutput calls f with the file at path, or stdout if path is empty
+func writeOutput(path string, f func(io.Writer) error) error {
+	if path == "" {
+		return f(os.Stdout)
+	}
+	return writeFileAtomic(path, privateFile, f)
+}
+
+// writeReport renders line items to out in the format given by opts. If errs
+// is set, the report is marked as incomplete in a way that suits the format.
+func writeReport(out io.Writer, allInfo []*userInfoLineItem, errs []*crawlError, opts *reportOptions) error {
+	switch opts.format() {
+	case "json":
+		if len(errs) != 0 {
+			return json.NewEncoder(out).Encode(&incompleteReport{Incomplete: true, Errors: errs, Items: allInfo})
+		}
+		return json.NewEncoder(out).Encode(allInfo)
+	case "xlsx":
+		return writeXLSXReport(out, allInfo, errs)
+	case "html":
+		return writeHTMLReport(out, allInfo, errs)
+	case "dot":
+		return writeDOT(out, allInfo, errs)
+	case "graphml":
+		return writeGraphML(out, allInfo, errs)
+	}
+
+	cols, err := opts.columns()
 	if err != nil {
 		return err
 	}
+	switch opts.format() {
+	case "csv":
+		if hasColumn(cols, "incomplete") {
+			allInfo = append(allInfo[:len(allInfo):len(allInfo)], incompleteRows(errs)...)
+		}
+		return writeCSVReport(out, allInfo, cols, !opts.NoHeader)
+	case "markdown":
+		return writeMarkdownReport(out, allInfo, cols, opts.GroupBy)
+	}
 
-	if outputJSON {
-		return json.NewEncoder(out).Encode(allInfo)
+	if opts.GroupBy == "org" {
+		cols = withoutColumn(cols, "organization")
+		for _, org := range groupByOrg(allInfo) {
+			fmt.Fprintf(out, "%s\n", org.Name)
+			writeTable(out, org.Items, cols, !opts.NoHeader)
+			fmt.Fprintln(out)
+		}
+		return nil
 	}
 
+	writeTable(out, allInfo, cols, !opts.NoHeader)
+	return nil
+}
+
+// writeTable renders items as a table
+func writeTable(out io.Writer, items []*userInfoLineItem, cols []*column, header bool) {
 	table := tablewriter.NewWriter(out)
-	table.SetHeader([]string{"Organization", "Space", "Username", "Role"})
-	for _, info := range allInfo {
-		table.Append([]string{info.Organization, info.Space, info.Username, info.Role})
+	if header {
+		table.SetHeader(columnHeaders(cols))
+	}
+	for _, info := range items {
+		table.Append(rowValues(info, cols))
 	}
 	table.Render()
-	return nil
+}
+
+// writeCSVReport writes items as CSV
+func writeCSVReport(out io.Writer, items []*userInfoLineItem, cols []*column, header bool) error {
+	w := csv.NewWriter(out)
+	if header {
+		err := w.Write(columnHeaders(cols))
+		if err != nil {
+			return err
+		}
+	}
+	for _, info := range items {
+		err := w.Write(rowValues(info, cols))
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeRows writes a header and rows as a table, csv or markdown, as chosen by
+// opts, for reports other than the user report. Table cells aren't wrapped,
+// so that values spanning several lines are kept as given.
+func writeRows(out io.Writer, header []string, rows [][]string, opts *reportOptions) error {
+	switch opts.format() {
+	case "csv":
+		w := csv.NewWriter(out)
+		if !opts.NoHeader {
+			w.Write(header)
+		}
+		w.WriteAll(rows)
+		return w.Error()
+	case "markdown":
+		w := bufio.NewWriter(out)
+		writeMarkdownRows(w, header, rows)
+		return w.Flush()
+	default:
+		table := tablewriter.NewWriter(out)
+		if !opts.NoHeader {
+			table.SetHeader(header)
+		}
+		table.SetAutoWrapText(false)
+		table.AppendBulk(rows)
+		table.Render()
+		return nil
+	}
 }
 
 func (c *reportUsers) GetMetadata() plugin.PluginMetadata {
@@ -275,9 +1132,77 @@ func (c *reportUsers) GetMetadata() plugin.PluginMetadata {
 					Usage: "cf report-users",
 					Options: map[string]string{
 						"output-json":          "if set sends JSON to stdout instead of a rendered table",
+						"output-format":        "output format, one of: table, json, csv, xlsx, html, markdown, dot, graphml",
+						"org":                  "if set only report on these orgs, comma separated",
+						"space":                "if set only report on these spaces, comma separated",
+						"username":             "if set only report on these users, comma separated",
+						"role":                 "if set only report on these roles, comma separated",
+						"isolation-segment":    "if set only report on orgs entitled to these isolation segments, comma separated",
+						"org-selector":         "if set only crawls orgs matching this label selector, ie env=prod",
+						"space-selector":       "if set only crawls spaces matching this label selector, and the orgs containing them",
+						"group-by":             "if set to \"org\", groups table and markdown output by org",
+						"columns":              "columns for table, csv and markdown output, comma separated, ie organization,space,username,role,origin,email,last_logon",
+						"sort":                 "if set sorts by these columns, comma separated, prefix with - for descending",
+						"no-header":            "if set omits the header row from table and csv output",
+						"foundations":          "if set crawls every foundation listed in this JSON config file",
+						"cross-foundation":     "if set with --foundations, reports only users with access to more than one foundation",
+						"output-file":          "if set writes output to this file instead of stdout, compressed if ending in .gz, {api-host}, {date} and {time} are expanded",
 						"quiet":                "if set suppresses printing of progress messages to stderr",
+						"verbose":              "if set logs every API request to stderr",
 						"org-users":            "if set include org-users role",
+						"continue-on-error":    "if set, failed requests are recorded and the crawl carries on, with affected rows marked incomplete and exit code 3",
 						"insecure-skip-verify": "if set disables TLS verification",
+						"ca-cert":              "if set trusts the CAs in this PEM file, as well as the system ones",
+						"client-cert":          "if set presents the client certificate in this PEM file, ie for an mTLS proxy",
+						"client-key":           "private key for --client-cert, in PEM format",
+						"proxy":                "if set sends all requests through this proxy, instead of using $HTTPS_PROXY and $NO_PROXY",
+						"max-idle-conns":       "how many keep-alive connections to keep open to each host",
+						"trace-file":           "if set writes every request and response body to this file as JSON lines, with tokens and emails redacted",
+						"trace-http":           "if set logs the headers of every request and response to stderr, with credentials redacted",
+						"save-archive":         "if set saves all raw API responses to this file",
+						"from-archive":         "if set reads API responses from this archive file instead of CloudFoundry",
+						"checkpoint":           "if set records each org in this file as it is crawled, so that an interrupted run can be resumed",
+						"timeout":              "if set, stops crawling after this long, and writes a partial report marked incomplete",
+						"request-timeout":      "how long a single API request may take before it is retried",
+						"metrics-file":         "if set writes Prometheus metrics to this file, in node-exporter textfile format",
+					},
+				},
+			},
+			{
+				Name:     "report-space-security",
+				HelpText: "Report which spaces allow SSH, their security groups, and who could SSH into apps",
+				UsageDetails: plugin.Usage{
+					Usage: "cf report-space-security",
+					Options: map[string]string{
+						"output-format":     "output format, one of: table, json, csv, markdown",
+						"output-file":       "if set writes output to this file instead of stdout",
+						"org":               "if set only report on these orgs, comma separated",
+						"space":             "if set only report on these spaces, comma separated",
+						"org-selector":      "if set only crawls orgs matching this label selector, ie env=prod",
+						"space-selector":    "if set only crawls spaces matching this label selector",
+						"no-header":         "if set omits the header row from table and csv output",
+						"quiet":             "if set suppresses printing of progress messages to stderr",
+						"verbose":           "if set logs every API request to stderr",
+						"continue-on-error": "if set, failed requests are recorded and the crawl carries on, with exit code 3",
+						"timeout":           "if set, stops crawling after this long, and writes a partial report",
+					},
+				},
+			},
+			{
+				Name:     "report-role-events",
+				HelpText: "Report who granted or revoked which roles, to whom, and when",
+				UsageDetails: plugin.Usage{
+					Usage: "cf report-role-events --since 30d",
+					Options: map[string]string{
+						"since":         "how far back to report, as a duration such as 30d or 12h, or a date such as 2024-01-31",
+						"output-format": "output format, one of: table, json, csv, markdown",
+						"output-file":   "if set writes output to this file instead of stdout",
+						"org":           "if set only report on these orgs, comma separated",
+						"space":         "if set only report on these spaces, comma separated",
+						"username":      "if set only report on these users, comma separated",
+						"role":          "if set only report on these roles, comma separated",
+						"no-header":     "if set omits the header row from table and csv output",
+						"verbose":       "if set logs every API request to stderr",
 					},
 				},
 			},
@@ -286,5 +1211,9 @@ func (c *reportUsers) GetMetadata() plugin.PluginMetadata {
 }
 
 func main() {
+	if isStandalone(os.Args) {
+		runStandalone(os.Args[1:])
+		return
+	}
 	plugin.Start(&reportUsers{})
 }
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFoundation returns a fake with one org, containing one space, and a
+// handful of users in each role
+func newTestFoundation(t *testing.T) *fakeCloudController {
+	fcc := newFakeCloudController(t)
+	fcc.SetList("/v2/organizations", v2Org("org-1", "org-one"))
+	fcc.addEmptyOrg("org-1")
+	fcc.SetList("/v2/organizations/org-1/users", v2User("u-1", "alice"), v2User("u-2", "bob"), v2User("u-3", "carol"))
+	fcc.SetList("/v2/organizations/org-1/managers", v2User("u-1", "alice"))
+	fcc.SetList("/v2/organizations/org-1/auditors", v2User("u-3", "carol"))
+	fcc.SetList("/v2/organizations/org-1/spaces", v2Space("space-1", "dev"))
+	fcc.addEmptySpace("space-1")
+	fcc.SetList("/v2/spaces/space-1/developers", v2User("u-2", "bob"), v2User("u-3", "carol"))
+	fcc.SetList("/v2/spaces/space-1/managers", v2User("u-1", "alice"))
+	return fcc
+}
+
+func runReport(t *testing.T, client ccClient, outputJSON, orgUsers bool) []byte {
+	var out bytes.Buffer
+	opts := &reportOptions{
+		OutputJSON: outputJSON,
+		OrgUsers:   orgUsers,
+	}
+	_, err := (&reportUsers{}).reportUsers(context.Background(), client, &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decodeReport(t *testing.T, b []byte) []userInfoLineItem {
+	var rv []userInfoLineItem
+	err := json.Unmarshal(b, &rv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rv
+}
+
+func TestListFollowsV2Pagination(t *testing.T) {
+	fcc := newFakeCloudController(t)
+	fcc.SetList("/v2/organizations",
+		v2Org("o1", "a"), v2Org("o2", "b"), v2Org("o3", "c"), v2Org("o4", "d"), v2Org("o5", "e"))
+
+	var names []string
+	err := fcc.client().List(context.Background(), "/v2/organizations", func(r *resource) error {
+		names = append(names, r.Entity.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"a", "b", "c", "d", "e"}) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+	if len(fcc.Requests()) != 3 {
+		t.Fatalf("expected 3 pages to be fetched, got: %v", fcc.Requests())
+	}
+}
+
+func TestListFollowsV3Pagination(t *testing.T) {
+	fcc := newFakeCloudController(t)
+	fcc.SetList("/v3/organizations",
+		map[string]string{"guid": "o1", "name": "a"},
+		map[string]string{"guid": "o2", "name": "b"},
+		map[string]string{"guid": "o3", "name": "c"})
+
+	var guids []string
+	err := fcc.client().List(context.Background(), "/v3/organizations", func(r *resource) error {
+		guids = append(guids, r.GUID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(guids, []string{"o1", "o2", "o3"}) {
+		t.Fatalf("unexpected guids: %v", guids)
+	}
+	if !reflect.DeepEqual(fcc.Requests(), []string{"/v3/organizations", "/v3/organizations?page=2"}) {
+		t.Fatalf("unexpected requests: %v", fcc.Requests())
+	}
+}
+
+func TestGetBadStatus(t *testing.T) {
+	fcc := newFakeCloudController(t)
+	var rv interface{}
+	if fcc.client().Get(context.Background(), "/v2/missing", &rv) == nil {
+		t.Fatal("expected error for 404")
+	}
+
+	client := fcc.client()
+	client.Authorization = "bearer wrong"
+	if client.Get(context.Background(), "/v2/organizations", &rv) == nil {
+		t.Fatal("expected error for 401")
+	}
+}
+
+func TestReportUsersRoleExpansion(t *testing.T) {
+	fcc := newTestFoundation(t)
+
+	got := decodeReport(t, runReport(t, fcc.client(), true, false))
+	expected := []userInfoLineItem{
+		{Organization: "org-one", OrgGUID: "org-1", Username: "alice", Role: "OrgManager", UserGUID: "u-1"},
+		{Organization: "org-one", OrgGUID: "org-1", Username: "carol", Role: "OrgAuditor", UserGUID: "u-3"},
+		{Organization: "org-one", OrgGUID: "org-1", Space: "dev", SpaceGUID: "space-1", Username: "bob", Role: "SpaceDeveloper", UserGUID: "u-2"},
+		{Organization: "org-one", OrgGUID: "org-1", Space: "dev", SpaceGUID: "space-1", Username: "carol", Role: "SpaceDeveloper", UserGUID: "u-3"},
+		{Organization: "org-one", OrgGUID: "org-1", Space: "dev", SpaceGUID: "space-1", Username: "alice", Role: "SpaceManager", UserGUID: "u-1"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected report:\n%+v\nexpected:\n%+v", got, expected)
+	}
+}
+
+func TestReportUsersOrgUsers(t *testing.T) {
+	fcc := newTestFoundation(t)
+
+	count := func(items []userInfoLineItem) int {
+		rv := 0
+		for _, item := range items {
+			if item.Role == "OrgUser" {
+				rv++
+			}
+		}
+		return rv
+	}
+
+	if n := count(decodeReport(t, runReport(t, fcc.client(), true, false))); n != 0 {
+		t.Fatalf("expected no OrgUser rows without --org-users, got %d", n)
+	}
+	if n := count(decodeReport(t, runReport(t, fcc.client(), true, true))); n != 3 {
+		t.Fatalf("expected 3 OrgUser rows with --org-users, got %d", n)
+	}
+}
+
+func TestReportUsersTable(t *testing.T) {
+	fcc := newTestFoundation(t)
+
+	out := string(runReport(t, fcc.client(), false, false))
+	for _, s := range []string{"ORGANIZATION", "SPACE", "USERNAME", "ROLE", "SpaceDeveloper", "alice"} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("expected table to contain %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestArchiveReplay(t *testing.T) {
+	fcc := newTestFoundation(t)
+
+	client := fcc.client()
+	archive := newCrawlArchive(client.API)
+	client.recordTo(archive)
+	live := runReport(t, client, true, true)
+
+	path := filepath.Join(t.TempDir(), "archive.json")
+	err := archive.save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fcc.Close()
+
+	loaded, err := loadCrawlArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline := runReport(t, newArchiveClient(loaded, false), true, true)
+	if !bytes.Equal(live, offline) {
+		t.Fatalf("replayed report differs:\n%s\n%s", live, offline)
+	}
+}
+
+func TestArchiveKeysOtherHosts(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"cc"`))
+	}))
+	defer cc.Close()
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"uaa"`))
+	}))
+	defer uaa.Close()
+
+	client := &simpleClient{API: cc.URL, client: http.DefaultClient}
+	archive := newCrawlArchive(cc.URL)
+	client.recordTo(archive)
+	var v string
+	for _, r := range []string{"/Users?count=1", uaa.URL + "/Users?count=1"} {
+		err := client.Get(context.Background(), r, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cc.Close()
+	uaa.Close()
+
+	offline := newArchiveClient(archive, false)
+	for r, expected := range map[string]string{"/Users?count=1": "cc", uaa.URL + "/Users?count=1": "uaa"} {
+		err := offline.Get(context.Background(), r, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != expected {
+			t.Fatalf("%s: got %q, expected %q", r, v, expected)
+		}
+	}
+}
+
+type fakeConnection struct {
+	api, token string
+}
+
+func (fc *fakeConnection) ApiEndpoint() (string, error) { return fc.api, nil }
+func (fc *fakeConnection) AccessToken() (string, error) { return fc.token, nil }
+func (fc *fakeConnection) IsSSLDisabled() (bool, error) { return false, nil }
+
+// expiringConnection returns token for the first call to AccessToken, and a
+// new token after that, as if the first had expired
+type expiringConnection struct {
+	fakeConnection
+	calls int
+}
+
+func (ec *expiringConnection) AccessToken() (string, error) {
+	ec.calls++
+	if ec.calls == 1 {
+		return ec.token, nil
+	}
+	return "bearer refreshed-token", nil
+}
+
+func TestTokenExpiresDuringCrawl(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.ExpireToken(fakeAuthorization, 3)
+	fcc.AcceptToken("bearer refreshed-token")
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	conn := &expiringConnection{fakeConnection: fakeConnection{api: fcc.URL, token: fakeAuthorization}}
+	client, err := newSimpleClient(conn, false, fcc.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeReport(t, runReport(t, client, true, false))
+	if len(got) != 5 {
+		t.Fatalf("expected 5 roles once the token was refreshed, got %+v", got)
+	}
+	// asking the cf CLI for a token makes it refresh with UAA, so only do so once refused
+	if conn.calls != 2 {
+		t.Fatalf("expected a new token to be fetched only once, got %d calls", conn.calls)
+	}
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	// expired in 2020, so must be replaced before use
+	expiring := "bearer eyJhbGciOiJSUzI1NiJ9.eyJleHAiOjE1Nzc4MzY4MDB9.c2ln"
+	conn := &expiringConnection{fakeConnection: fakeConnection{api: "https://api.example.com", token: expiring}}
+	client, err := newSimpleClient(conn, false, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := client.authorization()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "bearer refreshed-token" {
+			t.Fatalf("expected the expiring token to be replaced, got %s", got)
+		}
+	}
+	if conn.calls != 2 {
+		t.Fatalf("expected one refresh, got %d calls", conn.calls)
+	}
+}
+
+func TestNewSimpleClient(t *testing.T) {
+	fcc := newTestFoundation(t)
+
+	client, err := newSimpleClient(&fakeConnection{api: fcc.URL, token: fakeAuthorization}, false, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rv interface{}
+	err = client.Get(context.Background(), "/v2/organizations", &rv)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReportUsersFilter(t *testing.T) {
+	fcc := newTestFoundation(t)
+
+	var out bytes.Buffer
+	opts := &reportOptions{
+		OutputJSON: true,
+		Spaces:     "dev",
+		Roles:      "SpaceDeveloper, SpaceAuditor",
+	}
+	_, err := (&reportUsers{}).reportUsers(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeReport(t, out.Bytes())
+	if len(got) != 2 || got[0].Username != "bob" || got[1].Username != "carol" {
+		t.Fatalf("unexpected filtered report: %+v", got)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetList("/v2/organizations", v2Org("org-1", "org-one"), v2Org("org-2", "org-two"))
+	fcc.SetList("/v2/organizations/org-2/managers", v2User("u-4", "dave"))
+	fcc.SetList("/v2/organizations/org-2/spaces")
+	// org-2's billing managers and auditors are missing, so 404
+
+	_, err := (&reportUsers{}).crawlUsers(context.Background(), fcc.client(), &crawlOptions{})
+	if err == nil {
+		t.Fatal("expected the crawl to abort without ContinueOnError")
+	}
+
+	res, err := (&reportUsers{}).crawlUsers(context.Background(), fcc.client(), &crawlOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 6 {
+		t.Fatalf("expected 6 role assignments, got %d", len(res.Items))
+	}
+	for _, item := range res.Items {
+		if item.Incomplete != (item.Organization == "org-two") {
+			t.Fatalf("expected only org-two to be incomplete: %+v", item)
+		}
+	}
+
+	var summary bytes.Buffer
+	err = checkComplete(&summary, res, "roles")
+	if err != errIncomplete {
+		t.Fatalf("expected errIncomplete, got %v", err)
+	}
+	expected := "The report is incomplete, roles may be missing from these orgs and spaces:\n" +
+		"  org-two: GET /v2/organizations/org-2/billing_managers: bad status code: 404\n" +
+		"  org-two: GET /v2/organizations/org-2/auditors: bad status code: 404\n"
+	if summary.String() != expected {
+		t.Fatalf("unexpected summary:\n%s", summary.String())
+	}
+}
+
+// cancellingClient cancels a crawl as soon as a given URL is listed
+type cancellingClient struct {
+	ccClient
+	url    string
+	cancel context.CancelFunc
+}
+
+func (cc *cancellingClient) List(ctx context.Context, r string, f func(*resource) error) error {
+	if r == cc.url {
+		cc.cancel()
+	}
+	return cc.ccClient.List(ctx, r, f)
+}
+
+func TestInterruptedReport(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetList("/v2/organizations", v2Org("org-1", "org-one"), v2Org("org-2", "org-two"), v2Org("org-3", "org-three"))
+	fcc.addEmptyOrg("org-2")
+	fcc.SetList("/v2/organizations/org-2/managers", v2User("u-4", "dave"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &cancellingClient{ccClient: fcc.client(), url: "/v2/organizations/org-2/billing_managers", cancel: cancel}
+
+	var out bytes.Buffer
+	opts := &reportOptions{OutputFormat: "csv"}
+	res, err := (&reportUsers{}).reportUsers(ctx, client, &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 6 || len(res.Errors) != 2 {
+		t.Fatalf("expected org-one and part of org-two, got %d items and %d errors", len(res.Items), len(res.Errors))
+	}
+	if res.Errors[1].Error != "context canceled, 1 of 3 orgs not crawled" {
+		t.Fatalf("unexpected error: %s", res.Errors[1].Error)
+	}
+	// org-three was never crawled, so is only shown by the last row
+	if !strings.HasSuffix(out.String(), "org-two,,dave,OrgManager,yes\norg-two,,,,yes\n,,,,yes\n") {
+		t.Fatalf("expected rows from org-two, and the report, to be marked incomplete:\n%s", out.String())
+	}
+
+	for format, expected := range map[string]string{
+		"html":    "<b>This report is incomplete.</b> 2 requests failed or were interrupted, so some roles may be missing",
+		"dot":     `label="Incomplete: 2 requests failed or were interrupted, so some roles may be missing";`,
+		"graphml": `<data key="incomplete">2 requests failed or were interrupted, so some roles may be missing</data>`,
+	} {
+		out.Reset()
+		err = (&reportUsers{}).writeResult(&out, res, &reportOptions{OutputFormat: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %s output to be marked incomplete:\n%s", format, out.String())
+		}
+	}
+
+	var got incompleteReport
+	out.Reset()
+	err = (&reportUsers{}).writeResult(&out, res, &reportOptions{OutputFormat: "json"})
+	if err == nil {
+		err = json.Unmarshal(out.Bytes(), &got)
+	}
+	if err != nil || len(got.Items) != 6 || len(got.Errors) != 2 {
+		t.Fatalf("expected the roles and errors in the json report, got %+v: %v", got, err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer hung.Close()
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	client := &simpleClient{API: hung.URL, Timeout: 10 * time.Millisecond, client: hung.Client()}
+	var rv interface{}
+	err := client.Get(context.Background(), "/v2/info", &rv)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// securityRule is one rule of a security group
+type securityRule struct {
+	Protocol    string `json:"protocol"`
+	Destination string `json:"destination"`
+	Ports       string `json:"ports,omitempty"`
+	Type        *int   `json:"type,omitempty"` // icmp
+	Code        *int   `json:"code,omitempty"` // icmp
+}
+
+// summary returns the rule on one line, ie "tcp 10.0.0.0/8:443"
+func (r *securityRule) summary() string {
+	rv := r.Protocol + " " + r.Destination
+	if r.Ports != "" {
+		rv += ":" + r.Ports
+	}
+	if r.Type != nil {
+		rv += fmt.Sprintf(" type %d", *r.Type)
+	}
+	if r.Code != nil {
+		rv += fmt.Sprintf(" code %d", *r.Code)
+	}
+	return rv
+}
+
+// securityGroup is a security group that applies to a space, with its rules summarised
+type securityGroup struct {
+	Name string `json:"name"`
+
+	// Global is set for groups applied to every space by default, rather than bound to this one
+	Global bool     `json:"global,omitempty"`
+	Rules  []string `json:"rules"`
+}
+
+// summary returns the group on one line, ie "public_networks (global): all 0.0.0.0-9.255.255.255"
+func (g *securityGroup) summary() string {
+	name := g.Name
+	if g.Global {
+		name += " (global)"
+	}
+	return name + ": " + strings.Join(g.Rules, ", ")
+}
+
+func newSecurityGroup(res *resource, global bool) *securityGroup {
+	rv := &securityGroup{Name: res.Entity.Name, Global: global, Rules: []string{}}
+	for _, rule := range res.Entity.Rules {
+		rv.Rules = append(rv.Rules, rule.summary())
+	}
+	return rv
+}
+
+// spaceSecurityItem is the SSH and network exposure of one space
+type spaceSecurityItem struct {
+	Organization string `json:"organization"`
+	OrgGUID      string `json:"org_guid"`
+	Space        string `json:"space"`
+	SpaceGUID    string `json:"space_guid"`
+	AllowSSH     bool   `json:"allow_ssh"`
+
+	RunningSecurityGroups []*securityGroup `json:"running_security_groups"`
+	StagingSecurityGroups []*securityGroup `json:"staging_security_groups"`
+
+	// SSHUsers are the SpaceDevelopers, who could SSH into apps if AllowSSH is
+	// set. Apps may still have SSH disabled individually.
+	SSHUsers []string `json:"ssh_users"`
+
+	// Incomplete is set if some requests for this space failed, so it may be
+	// missing security groups or SSH users
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// spaceSecurityResult is everything found by crawlSpaceSecurity
+type spaceSecurityResult struct {
+	Spaces []*spaceSecurityItem
+
+	// Errors lists requests that failed, if crawling with ContinueOnError
+	Errors []*crawlError
+}
+
+// listSecurityGroups lists the security groups at r
+func listSecurityGroups(ctx context.Context, client ccClient, r string, global bool) ([]*securityGroup, error) {
+	rv := []*securityGroup{}
+	err := client.List(ctx, r, func(res *resource) error {
+		rv = append(rv, newSecurityGroup(res, global))
+		return nil
+	})
+	return rv, err
+}
+
+// crawlSpaceSecurity walks all orgs and spaces, as crawlUsers does, and
+// returns the security groups and SSH access of each space. If ctx is done
+// part way through, the spaces crawled so far are returned, with an error
+// recorded for the rest.
+func (c *reportUsers) crawlSpaceSecurity(ctx context.Context, client ccClient, co *crawlOptions) (*spaceSecurityResult, error) {
+	// the default groups apply to every space as well as those bound to it
+	globalRunning, err := listSecurityGroups(ctx, client, "/v2/config/running_security_groups", true)
+	if err != nil {
+		return nil, err
+	}
+	globalStaging, err := listSecurityGroups(ctx, client, "/v2/config/staging_security_groups", true)
+	if err != nil {
+		return nil, err
+	}
+
+	var orgs []*resource
+	err = client.List(ctx, "/v2/organizations", func(org *resource) error {
+		if co.Orgs == nil || co.Orgs[org.Metadata.GUID] {
+			orgs = append(orgs, org)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	co.Progress.addOrgs(len(orgs))
+
+	rv := &spaceSecurityResult{}
+	// fail records err if crawling with ContinueOnError or ctx is done, and
+	// marks item, if set, as incomplete
+	fail := func(item *spaceSecurityItem, org, space, r string, err error) error {
+		if ctx.Err() == nil && !co.ContinueOnError {
+			return err
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if item != nil {
+			item.Incomplete = true
+		}
+		rv.Errors = append(rv.Errors, &crawlError{Organization: org, Space: space, URL: r, Error: err.Error()})
+		return nil
+	}
+
+	for _, org := range orgs {
+		if ctx.Err() != nil {
+			break
+		}
+		var spaces []*resource
+		err := client.List(ctx, org.Entity.SpacesURL, func(space *resource) error {
+			if co.Spaces == nil || co.Spaces[space.Metadata.GUID] {
+				spaces = append(spaces, space)
+			}
+			return nil
+		})
+		if err != nil {
+			err = fail(nil, org.Entity.Name, "", org.Entity.SpacesURL, err)
+			if err != nil {
+				return nil, err
+			}
+		}
+		co.Progress.addSpaces(len(spaces))
+
+		for _, space := range spaces {
+			item := &spaceSecurityItem{
+				Organization:          org.Entity.Name,
+				OrgGUID:               org.Metadata.GUID,
+				Space:                 space.Entity.Name,
+				SpaceGUID:             space.Metadata.GUID,
+				AllowSSH:              space.Entity.AllowSSH,
+				RunningSecurityGroups: append([]*securityGroup{}, globalRunning...),
+				StagingSecurityGroups: append([]*securityGroup{}, globalStaging...),
+				SSHUsers:              []string{},
+			}
+			r, err := c.crawlSpaceExposure(ctx, client, space, item)
+			if err != nil {
+				err = fail(item, org.Entity.Name, space.Entity.Name, r, err)
+				if err != nil {
+					return nil, err
+				}
+			}
+			rv.Spaces = append(rv.Spaces, item)
+			co.Progress.spaceDone()
+		}
+		co.Progress.orgDone()
+	}
+	if ctx.Err() != nil {
+		rv.Errors = append(rv.Errors, &crawlError{Error: fmt.Sprintf("%s, some orgs not crawled", ctx.Err())})
+	}
+	return rv, nil
+}
+
+// crawlSpaceExposure adds the security groups bound to space, and if it
+// allows SSH, the SpaceDevelopers who could use it, to item. On failure the
+// URL of the failed request is returned too.
+func (c *reportUsers) crawlSpaceExposure(ctx context.Context, client ccClient, space *resource, item *spaceSecurityItem) (string, error) {
+	running, err := listSecurityGroups(ctx, client, space.Entity.SecurityGroupsURL, false)
+	if err != nil {
+		return space.Entity.SecurityGroupsURL, err
+	}
+	item.RunningSecurityGroups = append(item.RunningSecurityGroups, running...)
+
+	staging, err := listSecurityGroups(ctx, client, space.Entity.StagingSecurityGroupsURL, false)
+	if err != nil {
+		return space.Entity.StagingSecurityGroupsURL, err
+	}
+	item.StagingSecurityGroups = append(item.StagingSecurityGroups, staging...)
+
+	if !space.Entity.AllowSSH {
+		return "", nil
+	}
+	err = client.List(ctx, space.Entity.DevelopersURL, func(user *resource) error {
+		item.SSHUsers = append(item.SSHUsers, user.Entity.Username)
+		return nil
+	})
+	if err != nil {
+		return space.Entity.DevelopersURL, err
+	}
+	sort.Strings(item.SSHUsers)
+	return "", nil
+}
+
+// spaceSecurityMissing is what may be missing from an incomplete space security report
+const spaceSecurityMissing = "security groups and SSH users"
+
+// validateSpaceSecurity checks the options used by report-space-security
+func (o *reportOptions) validateSpaceSecurity() error {
+	if !matchesAny([]string{"table", "json", "csv", "markdown"}, o.format()) {
+		return fmt.Errorf("report-space-security does not support %s output", o.format())
+	}
+	if o.Foundations != "" || o.Checkpoint != "" {
+		return errors.New("report-space-security can't be used with --foundations or --checkpoint")
+	}
+	return checkCompression(o.OutputFile, o.SaveArchive, o.FromArchive)
+}
+
+// reportSpaceSecurity runs the report-space-security command
+func (c *reportUsers) reportSpaceSecurity(conn cfConnection, opts *reportOptions) error {
+	err := opts.validateSpaceSecurity()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := interruptible(opts.Timeout)
+	defer cancel()
+	client, archive, closeTrace, err := c.newTracedClient(conn, opts)
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+	co := opts.crawlOptions()
+	co.Progress = opts.progress()
+	client.Progress = co.Progress
+
+	now := time.Now()
+	var res *spaceSecurityResult
+	err = writeOutput(expandOutputPath(opts.OutputFile, client.API, now), func(out io.Writer) error {
+		var err error
+		res, err = c.spaceSecurityReport(ctx, client, out, opts, co)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if opts.SaveArchive != "" {
+		err = archive.save(expandOutputPath(opts.SaveArchive, client.API, now))
+		if err != nil {
+			return err
+		}
+	}
+	return checkComplete(os.Stderr, &crawlResult{Errors: res.Errors}, spaceSecurityMissing)
+}
+
+// spaceSecurityReport crawls the spaces selected by opts and co, and writes the report to out
+func (c *reportUsers) spaceSecurityReport(ctx context.Context, client ccClient, out io.Writer, opts *reportOptions, co *crawlOptions) (*spaceSecurityResult, error) {
+	stop := opts.showProgress(co.Progress)
+	err := applySelectors(ctx, client, opts, co)
+	var res *spaceSecurityResult
+	if err == nil {
+		res, err = c.crawlSpaceSecurity(ctx, client, co)
+	}
+	stop()
+	if err != nil {
+		return nil, err
+	}
+
+	orgs, spaces := splitList(opts.Orgs), splitList(opts.Spaces)
+	items := []*spaceSecurityItem{}
+	for _, item := range res.Spaces {
+		if matchesAny(orgs, item.Organization) && matchesAny(spaces, item.Space) {
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Organization != items[j].Organization {
+			return items[i].Organization < items[j].Organization
+		}
+		return items[i].Space < items[j].Space
+	})
+	err = writeSpaceSecurityReport(out, items, opts)
+	if err != nil {
+		return nil, err
+	}
+	writeIncompleteNote(out, &crawlResult{Errors: res.Errors}, opts.format(), spaceSecurityMissing)
+	return res, nil
+}
+
+// writeSpaceSecurityReport renders items in the format given by opts
+func writeSpaceSecurityReport(out io.Writer, items []*spaceSecurityItem, opts *reportOptions) error {
+	if opts.format() == "json" {
+		return json.NewEncoder(out).Encode(items)
+	}
+
+	// groups are one per line, except in markdown where cells can't span lines
+	sep := "\n"
+	if opts.format() == "markdown" {
+		sep = "; "
+	}
+	summarise := func(groups []*securityGroup) string {
+		var rv []string
+		for _, g := range groups {
+			rv = append(rv, g.summary())
+		}
+		return strings.Join(rv, sep)
+	}
+	header := []string{"Organization", "Space", "SSH", "Running Security Groups", "Staging Security Groups", "SSH Users", "Incomplete"}
+	var rows [][]string
+	for _, item := range items {
+		ssh := "no"
+		if item.AllowSSH {
+			ssh = "yes"
+		}
+		incomplete := ""
+		if item.Incomplete {
+			incomplete = "yes"
+		}
+		rows = append(rows, []string{
+			item.Organization,
+			item.Space,
+			ssh,
+			summarise(item.RunningSecurityGroups),
+			summarise(item.StagingSecurityGroups),
+			strings.Join(item.SSHUsers, ", "),
+			incomplete,
+		})
+	}
+
+	return writeRows(out, header, rows, opts)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2SecurityGroup returns a security group fixture with one rule
+func v2SecurityGroup(guid, name, protocol, destination, ports string) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"guid": guid},
+		"entity": map[string]interface{}{
+			"name": name,
+			"rules": []interface{}{
+				map[string]interface{}{"protocol": protocol, "destination": destination, "ports": ports},
+			},
+		},
+	}
+}
+
+func TestSpaceSecurityReport(t *testing.T) {
+	fcc := newTestFoundation(t)
+	dev := v2Space("space-1", "dev")
+	dev["entity"].(map[string]interface{})["allow_ssh"] = true
+	dev["entity"].(map[string]interface{})["security_groups_url"] = "/v2/spaces/space-1/security_groups"
+	dev["entity"].(map[string]interface{})["staging_security_groups_url"] = "/v2/spaces/space-1/staging_security_groups"
+	prod := v2Space("space-2", "prod")
+	prod["entity"].(map[string]interface{})["security_groups_url"] = "/v2/spaces/space-2/security_groups"
+	prod["entity"].(map[string]interface{})["staging_security_groups_url"] = "/v2/spaces/space-2/staging_security_groups"
+	fcc.SetList("/v2/organizations/org-1/spaces", dev, prod)
+	fcc.SetList("/v2/config/running_security_groups", v2SecurityGroup("sg-1", "dns", "udp", "0.0.0.0/0", "53"))
+	fcc.SetList("/v2/config/staging_security_groups")
+	fcc.SetList("/v2/spaces/space-1/security_groups", v2SecurityGroup("sg-2", "db", "tcp", "10.0.0.0/8", "5432"))
+	fcc.SetList("/v2/spaces/space-1/staging_security_groups")
+	fcc.SetList("/v2/spaces/space-2/security_groups")
+	fcc.SetList("/v2/spaces/space-2/staging_security_groups")
+
+	var out bytes.Buffer
+	opts := &reportOptions{OutputFormat: "json"}
+	res, err := (&reportUsers{}).spaceSecurityReport(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", res.Errors)
+	}
+
+	var got []*spaceSecurityItem
+	err = json.Unmarshal(out.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns := &securityGroup{Name: "dns", Global: true, Rules: []string{"udp 0.0.0.0/0:53"}}
+	expected := []*spaceSecurityItem{
+		{
+			Organization:          "org-one",
+			OrgGUID:               "org-1",
+			Space:                 "dev",
+			SpaceGUID:             "space-1",
+			AllowSSH:              true,
+			RunningSecurityGroups: []*securityGroup{dns, {Name: "db", Rules: []string{"tcp 10.0.0.0/8:5432"}}},
+			StagingSecurityGroups: []*securityGroup{},
+			SSHUsers:              []string{"bob", "carol"},
+		},
+		{
+			Organization:          "org-one",
+			OrgGUID:               "org-1",
+			Space:                 "prod",
+			SpaceGUID:             "space-2",
+			RunningSecurityGroups: []*securityGroup{dns},
+			StagingSecurityGroups: []*securityGroup{},
+			SSHUsers:              []string{},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		a, _ := json.Marshal(got)
+		t.Fatalf("unexpected report:\n%s", a)
+	}
+
+	out.Reset()
+	opts = &reportOptions{OutputFormat: "csv", Spaces: "dev", NoHeader: true}
+	_, err = (&reportUsers{}).spaceSecurityReport(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv := "org-one,dev,yes,\"dns (global): udp 0.0.0.0/0:53\ndb: tcp 10.0.0.0/8:5432\",,\"bob, carol\",\n"
+	if out.String() != csv {
+		t.Fatalf("unexpected csv:\n%s", out.String())
+	}
+}
+
+func TestSpaceSecurityReportIncomplete(t *testing.T) {
+	fcc := newTestFoundation(t)
+	dev := v2Space("space-1", "dev")
+	dev["entity"].(map[string]interface{})["security_groups_url"] = "/v2/spaces/space-1/security_groups"
+	dev["entity"].(map[string]interface{})["staging_security_groups_url"] = "/v2/spaces/space-1/staging_security_groups"
+	fcc.SetList("/v2/organizations/org-1/spaces", dev)
+	fcc.SetList("/v2/config/running_security_groups")
+	fcc.SetList("/v2/config/staging_security_groups")
+	fcc.SetList("/v2/spaces/space-1/staging_security_groups")
+	fcc.Fail("/v2/spaces/space-1/security_groups", maxRetries+1)
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	var out bytes.Buffer
+	opts := &reportOptions{OutputFormat: "json", ContinueOnError: true}
+	res, err := (&reportUsers{}).spaceSecurityReport(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) != 1 {
+		t.Fatalf("expected one error, got %+v", res.Errors)
+	}
+	var summary bytes.Buffer
+	if checkComplete(&summary, &crawlResult{Errors: res.Errors}, spaceSecurityMissing) != errIncomplete ||
+		!strings.HasPrefix(summary.String(), "The report is incomplete, security groups and SSH users may be missing") {
+		t.Fatalf("unexpected summary:\n%s", summary.String())
+	}
+	var got []*spaceSecurityItem
+	err = json.Unmarshal(out.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].Incomplete {
+		t.Fatalf("expected the space to be marked incomplete:\n%s", out.String())
+	}
+
+	for format, expected := range map[string]string{
+		"csv":      "org-one,dev,no,,,,yes\n",
+		"markdown": "**Incomplete:** 1 requests failed or were interrupted, so some security groups and SSH users may be missing",
+		"table":    "Incomplete: 1 requests failed or were interrupted, so some security groups and SSH users may be missing",
+	} {
+		fcc.Fail("/v2/spaces/space-1/security_groups", maxRetries+1)
+		out.Reset()
+		opts := &reportOptions{OutputFormat: format, ContinueOnError: true, NoHeader: true}
+		_, err := (&reportUsers{}).spaceSecurityReport(context.Background(), fcc.client(), &out, opts, opts.crawlOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %s output to contain %q:\n%s", format, expected, out.String())
+		}
+	}
+}
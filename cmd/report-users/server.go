@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportServer serves the results of the most recent successful crawl
+type reportServer struct {
+	mu      sync.RWMutex
+	metrics crawlMetrics
+}
+
+// serve crawls CloudFoundry every opts.Interval, and serves the latest results over HTTP
+func (c *reportUsers) serve(conn cfConnection, opts *reportOptions) error {
+	// the token is read from the environment rather than shown as a flag default
+	token := opts.ServeToken
+	if token == "" {
+		token = os.Getenv("REPORT_USERS_SERVE_TOKEN")
+	}
+	if token == "" && !isLoopback(opts.Listen) {
+		return fmt.Errorf("--serve-token must be set to listen on %s, as every user's roles would be visible to anyone who can connect", opts.Listen)
+	}
+
+	srv := &reportServer{}
+	handler := srv.handler()
+	if token != "" {
+		handler = requireToken(token, handler)
+	}
+	go srv.crawlEvery(opts.Interval, func() (*crawlResult, error) {
+		// new client each time, so that we pick up a fresh token
+		client, _, err := c.newClient(conn, opts)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.crawlUsers(context.Background(), client, &crawlOptions{
+			IncludeOrgUsers: opts.OrgUsers,
+			ContinueOnError: opts.ContinueOnError,
+		})
+		if err != nil {
+			return nil, err
+		}
+		lookupOrigins(context.Background(), client, res.Items)
+		return res, nil
+	})
+
+	log.Printf("listening on %s", opts.Listen)
+	return http.ListenAndServe(opts.Listen, handler)
+}
+
+// isLoopback returns true if addr, ie "127.0.0.1:8080", only accepts local connections
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireToken only passes on requests that give token, either as a bearer
+// token for API clients and Prometheus, or as a basic auth password for browsers
+func requireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var given string
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		} else if _, password, ok := r.BasicAuth(); ok {
+			given = password
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="report-users"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// crawlEvery calls crawl immediately, and then every interval, forever. Failed
+// crawls are logged and the previous results kept.
+func (srv *reportServer) crawlEvery(interval time.Duration, crawl func() (*crawlResult, error)) {
+	for {
+		srv.update(crawl())
+		time.Sleep(interval)
+	}
+}
+
+func (srv *reportServer) update(res *crawlResult, err error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.metrics.Crawls++
+	if err != nil {
+		srv.metrics.CrawlErrors++
+		log.Printf("crawl failed: %s", err)
+		return
+	}
+	srv.metrics.Last = res
+	srv.metrics.LastSuccess = time.Now()
+	log.Printf("crawl complete, %d role assignments found in %s", len(res.Items), res.Duration)
+	if len(res.Errors) != 0 {
+		log.Printf("%d requests failed during the crawl, so some roles may be missing", len(res.Errors))
+	}
+}
+
+// snapshot returns the current results, or false if no crawl has succeeded yet
+func (srv *reportServer) snapshot() ([]*userInfoLineItem, time.Time, bool) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	if srv.metrics.Last == nil {
+		return nil, time.Time{}, false
+	}
+	return srv.metrics.Last.Items, srv.metrics.LastSuccess, true
+}
+
+func (srv *reportServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/roles", srv.serveRoles)
+	mux.HandleFunc("/api/users/", srv.serveUser)
+	mux.HandleFunc("/metrics", srv.serveMetrics)
+	mux.HandleFunc("/", srv.serveIndex)
+	return mux
+}
+
+// ready writes an error and returns false if there is nothing to serve yet
+func (srv *reportServer) ready(w http.ResponseWriter, r *http.Request) ([]*userInfoLineItem, time.Time, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, time.Time{}, false
+	}
+	items, updated, ok := srv.snapshot()
+	if !ok {
+		http.Error(w, "first crawl has not yet completed", http.StatusServiceUnavailable)
+		return nil, time.Time{}, false
+	}
+	w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	return items, updated, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("error writing response: %s", err)
+	}
+}
+
+// serveRoles returns role assignments as JSON, filtered by the query parameters
+// organization, space, username, role and user_guid, each of which may be repeated
+func (srv *reportServer) serveRoles(w http.ResponseWriter, r *http.Request) {
+	items, _, ok := srv.ready(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	filter := &roleFilter{
+		Organizations: q["organization"],
+		Spaces:        q["space"],
+		Usernames:     q["username"],
+		Roles:         q["role"],
+		UserGUIDs:     q["user_guid"],
+	}
+	writeJSON(w, filter.apply(items))
+}
+
+// serveUser returns all roles held by the user whose GUID is at the end of the path
+func (srv *reportServer) serveUser(w http.ResponseWriter, r *http.Request) {
+	items, _, ok := srv.ready(w, r)
+	if !ok {
+		return
+	}
+	guid := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if guid == "" || strings.Contains(guid, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	roles := (&roleFilter{UserGUIDs: []string{guid}}).apply(items)
+	if len(roles) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, struct {
+		UserGUID string              `json:"user_guid"`
+		Username string              `json:"username"`
+		Roles    []*userInfoLineItem `json:"roles"`
+	}{
+		UserGUID: guid,
+		Username: roles[0].Username,
+		Roles:    roles,
+	})
+}
+
+// serveMetrics returns Prometheus metrics, which are available even before the first crawl succeeds
+func (srv *reportServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	srv.mu.RLock()
+	m := srv.metrics
+	srv.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err := writeMetrics(w, &m)
+	if err != nil {
+		log.Printf("error writing metrics: %s", err)
+	}
+}
+
+type orgAccess struct {
+	Foundation   string
+	Organization string
+
+	// Name labels the org, with its foundation if set, ie "system (prod)"
+	Name  string
+	Items []*userInfoLineItem
+}
+
+// orgLabel returns the name to show for an org, which includes the
+// foundation if set, as orgs in different foundations may share a name
+func orgLabel(foundation, org string) string {
+	if foundation == "" {
+		return org
+	}
+	return org + " (" + foundation + ")"
+}
+
+// groupByOrg returns items grouped by foundation and organization, with
+// organizations sorted by name, then foundation
+func groupByOrg(items []*userInfoLineItem) []*orgAccess {
+	type orgKey struct{ foundation, org string }
+	byKey := make(map[orgKey]*orgAccess)
+	var rv []*orgAccess
+	for _, item := range items {
+		k := orgKey{item.Foundation, item.Organization}
+		oa, ok := byKey[k]
+		if !ok {
+			oa = &orgAccess{
+				Foundation:   item.Foundation,
+				Organization: item.Organization,
+				Name:         orgLabel(item.Foundation, item.Organization),
+			}
+			byKey[k] = oa
+			rv = append(rv, oa)
+		}
+		oa.Items = append(oa.Items, item)
+	}
+	sort.SliceStable(rv, func(i, j int) bool {
+		if rv[i].Organization != rv[j].Organization {
+			return rv[i].Organization < rv[j].Organization
+		}
+		return rv[i].Foundation < rv[j].Foundation
+	})
+	return rv
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CloudFoundry user access</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.75em; text-align: left; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>CloudFoundry user access</h1>
+<p>Last updated {{.Updated.Format "2006-01-02 15:04:05 MST"}}.</p>
+<ul>
+{{range .Orgs}}<li><a href="#org-{{.Name}}">{{.Name}}</a></li>
+{{end}}</ul>
+{{range .Orgs}}
+<h2 id="org-{{.Name}}">{{.Name}}</h2>
+<table>
+<tr><th>Space</th><th>Username</th><th>Role</th></tr>
+{{range .Items}}<tr><td>{{.Space}}</td><td>{{if .UserGUID}}<a href="/api/users/{{.UserGUID}}">{{.Username}}</a>{{else}}{{.Username}}{{end}}</td><td>{{.Role}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// serveIndex renders an HTML page listing access per organization
+func (srv *reportServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	items, updated, ok := srv.ready(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := indexTemplate.Execute(w, struct {
+		Updated time.Time
+		Orgs    []*orgAccess
+	}{
+		Updated: updated,
+		Orgs:    groupByOrg(items),
+	})
+	if err != nil {
+		log.Printf("error rendering index: %s", err)
+	}
+}
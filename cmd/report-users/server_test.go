@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReportServer(t *testing.T) {
+	srv := &reportServer{}
+	handler := srv.handler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if code := get("/api/roles").Code; code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before first crawl, got %d", code)
+	}
+
+	fcc := newTestFoundation(t)
+	srv.update((&reportUsers{}).crawlUsers(context.Background(), fcc.client(), &crawlOptions{}))
+
+	var roles []userInfoLineItem
+	err := json.NewDecoder(get("/api/roles?role=SpaceDeveloper&username=bob").Body).Decode(&roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0].Space != "dev" {
+		t.Fatalf("unexpected roles: %+v", roles)
+	}
+
+	var user struct {
+		Username string
+		Roles    []userInfoLineItem
+	}
+	err = json.NewDecoder(get("/api/users/u-1").Body).Decode(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" || len(user.Roles) != 2 {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+	if code := get("/api/users/nobody").Code; code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown user, got %d", code)
+	}
+
+	if body := get("/").Body.String(); !strings.Contains(body, "org-one") {
+		t.Fatalf("expected index to list org:\n%s", body)
+	}
+
+	metrics := get("/metrics").Body.String()
+	for _, s := range []string{
+		"cf_crawls_total 1\n",
+		`cf_role_assignments{org="org-one",space="dev",role="SpaceDeveloper"} 2`,
+		`cf_users_total{origin="unknown"} 3`,
+		"cf_spaces_without_manager 0\n",
+	} {
+		if !strings.Contains(metrics, s) {
+			t.Fatalf("expected metrics to contain %q:\n%s", s, metrics)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	handler := requireToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		Auth func(*http.Request)
+		Code int
+	}{
+		{func(r *http.Request) {}, http.StatusUnauthorized},
+		{func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{func(r *http.Request) { r.Header.Set("Authorization", "s3cret") }, http.StatusUnauthorized},
+		{func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{func(r *http.Request) { r.SetBasicAuth("anyone", "s3cret") }, http.StatusOK},
+		{func(r *http.Request) { r.SetBasicAuth("anyone", "wrong") }, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/roles", nil)
+		tc.Auth(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.Code {
+			t.Errorf("%v: expected %d, got %d", req.Header, tc.Code, rec.Code)
+		}
+	}
+
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"[::1]:8080":     true,
+		"localhost:8080": true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.1:8080":  false,
+	} {
+		if isLoopback(addr) != expected {
+			t.Errorf("%s: expected loopback %v", addr, expected)
+		}
+	}
+
+	err := (&reportUsers{}).serve(&fakeConnection{}, &reportOptions{Listen: ":0"})
+	if err == nil || !strings.Contains(err.Error(), "--serve-token") {
+		t.Fatalf("expected serving on all interfaces without a token to be refused, got %v", err)
+	}
+}
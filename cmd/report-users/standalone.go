@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isStandalone returns true if we were run directly rather than by the cf CLI,
+// which always passes the port of its RPC server as the first argument
+func isStandalone(args []string) bool {
+	if len(args) < 2 {
+		return true
+	}
+	_, err := strconv.Atoi(args[1])
+	return err != nil
+}
+
+// runStandalone runs a command without the cf CLI, where args are
+// the command line arguments excluding the program name, ie:
+//
+//	report-users [command] --api https://api.system.example.com --client-id x --client-secret y
+//
+// If command is omitted, it defaults to "report-users". If --api is omitted,
+// the target and tokens saved by "cf login" in $CF_HOME/.cf/config.json are used.
+func runStandalone(args []string) {
+	command := "report-users"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var opts reportOptions
+	conn := &uaaConnection{}
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&conn.API, "api", os.Getenv("CF_API"), "API url, ie https://api.system.example.com (default $CF_API)")
+	fs.StringVar(&conn.Token, "token", "", "if set, use this access token rather than fetching one from UAA")
+	fs.StringVar(&conn.ClientID, "client-id", os.Getenv("CF_CLIENT_ID"), "UAA client ID (default $CF_CLIENT_ID)")
+	fs.StringVar(&conn.ClientSecret, "client-secret", "", "UAA client secret (default $CF_CLIENT_SECRET)")
+	fs.StringVar(&conn.RefreshToken, "refresh-token", "", "if set, exchange this refresh token for access tokens (default $CF_REFRESH_TOKEN)")
+	err := fs.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// secrets are read from the environment rather than shown as flag defaults
+	if conn.ClientSecret == "" {
+		conn.ClientSecret = os.Getenv("CF_CLIENT_SECRET")
+	}
+	if conn.RefreshToken == "" {
+		conn.RefreshToken = os.Getenv("CF_REFRESH_TOKEN")
+	}
+
+	if opts.FromArchive == "" && conn.API == "" {
+		// fall back to whatever "cf login" last saved
+		path, err := cfConfigPath()
+		if err != nil {
+			log.Fatal(err)
+		}
+		config, err := loadCFConfig(path)
+		if err != nil || config.Target == "" {
+			fmt.Fprintf(os.Stderr, "--api must be set when run outside of the cf CLI, unless logged in with \"cf login\"\n\n")
+			fs.Usage()
+			os.Exit(2)
+		}
+		conn = config.fallback(conn)
+	}
+	conn.client, err = opts.httpClient(conn.SSLDisabled)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exitOnError((&reportUsers{}).run(conn, command, &opts))
+}
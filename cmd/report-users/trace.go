@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// traceEntry is one line of a --trace-file
+type traceEntry struct {
+	Time       time.Time       `json:"time"`
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Status     int             `json:"status,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+// traceFile writes a traceEntry for every request, as JSON lines, so that a
+// crawl can be examined or replayed without access to the foundation
+type traceFile struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func createTraceFile(path string) (*traceFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &traceFile{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (tf *traceFile) write(e *traceEntry) error {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	return tf.enc.Encode(e)
+}
+
+func (tf *traceFile) Close() error {
+	return tf.f.Close()
+}
+
+var (
+	// emailPattern matches email addresses anywhere in a string
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+	// jwtPattern matches JWTs, such as access tokens, anywhere in a string
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+	// secretKeys are JSON object keys whose values are always redacted
+	secretKeys = []string{"access_token", "refresh_token", "id_token", "client_secret", "password"}
+)
+
+// redactText replaces tokens, and replaces email addresses with a pseudonym
+// derived from a hash, so that the same address is always replaced the same
+// way, and users can still be told apart
+func redactText(s string) string {
+	s = jwtPattern.ReplaceAllString(s, "[REDACTED]")
+	return emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		sum := sha256.Sum256([]byte(email))
+		return fmt.Sprintf("user-%x@redacted.invalid", sum[:4])
+	})
+}
+
+// redactJSON redacts every string in a decoded JSON value
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range v {
+			if matchesAny(secretKeys, k) {
+				v[k] = "[REDACTED]"
+			} else {
+				v[k] = redactJSON(vv)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, vv := range v {
+			v[i] = redactJSON(vv)
+		}
+		return v
+	case string:
+		return redactText(v)
+	default:
+		return v
+	}
+}
+
+// redactBody returns a response body, redacted, as JSON. Bodies that aren't
+// JSON are kept as a string.
+func redactBody(body []byte) json.RawMessage {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil {
+		v = string(body)
+	}
+	rv, err := json.Marshal(redactJSON(v))
+	if err != nil {
+		return nil
+	}
+	return rv
+}
+
+// tracingFileTransport wraps another transport, and writes every request to a trace file
+type tracingFileTransport struct {
+	trace *traceFile
+	base  http.RoundTripper
+}
+
+func (t *tracingFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	e := &traceEntry{
+		Time:   start.UTC(),
+		Method: req.Method,
+		URL:    redactText(req.URL.String()),
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		e.Error = err.Error()
+		e.DurationMS = time.Since(start).Milliseconds()
+		t.trace.write(e)
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	e.Status = resp.StatusCode
+	e.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		e.Error = err.Error()
+		t.trace.write(e)
+		return nil, err
+	}
+	e.Body = redactBody(body)
+	err = t.trace.write(e)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// traceTo wraps the client's transport so that all requests are also written to trace
+func (sc *simpleClient) traceTo(trace *traceFile) {
+	base := sc.client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	sc.client = &http.Client{
+		Transport: &tracingFileTransport{
+			trace: trace,
+			base:  base,
+		},
+	}
+}
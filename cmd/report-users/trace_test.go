@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactText(t *testing.T) {
+	got := redactText("erin@example.com has token eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJlcmluIn0.c2ln")
+	if got != redactText("erin@example.com")+" has token [REDACTED]" || strings.Contains(got, "erin") {
+		t.Fatalf("unexpected redaction: %s", got)
+	}
+	if redactText("erin@example.com") == redactText("frank@example.com") {
+		t.Fatal("expected different emails to be told apart")
+	}
+}
+
+func TestTraceFileReplay(t *testing.T) {
+	fcc := newTestFoundation(t)
+	fcc.SetList("/v2/organizations/org-1/billing_managers", v2User("u-5", "erin@example.com"))
+	fcc.SetObject("/v2/info", map[string]string{"token_endpoint": "https://uaa.example.com", "access_token": "secret"})
+
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	trace, err := createTraceFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fcc.client()
+	client.traceTo(trace)
+	var info interface{}
+	err = client.Get(context.Background(), "/v2/info", &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := (&reportUsers{}).crawlUsers(context.Background(), client, &crawlOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "erin@example.com") || strings.Contains(string(b), "secret") {
+		t.Fatalf("expected emails and tokens to be redacted:\n%s", b)
+	}
+
+	// a fake with no fixtures of its own reproduces the crawl from the trace
+	replay := newFakeCloudController(t)
+	replay.Replay(t, path)
+	replayed, err := (&reportUsers{}).crawlUsers(context.Background(), replay.client(), &crawlOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range recorded.Items {
+		item.Username = redactText(item.Username)
+	}
+	if !reflect.DeepEqual(recorded.Items, replayed.Items) {
+		t.Fatalf("replayed crawl differs from the recorded one")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transportOptions configures how we connect to CloudFoundry, how we verify
+// it, and how we identify ourselves to it
+type transportOptions struct {
+	// InsecureSkipVerify disables verification entirely
+	InsecureSkipVerify bool
+
+	// CACert is a PEM file of CAs to trust, in addition to the system ones,
+	// ie for foundations using an internal CA
+	CACert string
+
+	// ClientCert and ClientKey are PEM files for a client certificate,
+	// ie for an mTLS proxy in front of the API
+	ClientCert string
+	ClientKey  string
+
+	// Proxy, if set, is used for all requests, instead of the proxy chosen
+	// by $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY
+	Proxy string
+
+	// MaxIdleConnsPerHost is how many keep-alive connections are kept open
+	// to each host, for reuse by later and concurrent requests
+	MaxIdleConnsPerHost int
+
+	// Trace logs every request and response, without bodies, and with
+	// credentials redacted
+	Trace bool
+}
+
+// tlsConfig returns the tls.Config for these options
+func (t *transportOptions) tlsConfig() (*tls.Config, error) {
+	rv := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(t.CACert)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CACert)
+		}
+		rv.RootCAs = pool
+	}
+
+	if t.ClientCert != "" || t.ClientKey != "" {
+		if t.ClientCert == "" || t.ClientKey == "" {
+			return nil, errors.New("--client-cert and --client-key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		rv.Certificates = []tls.Certificate{cert}
+	}
+
+	return rv, nil
+}
+
+// httpClient returns a client using these options. Unless Proxy is set, the
+// proxy is chosen from the environment, and HTTP/2 is used if the server supports it.
+func (t *transportOptions) httpClient() (*http.Client, error) {
+	config, err := t.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	transport.ForceAttemptHTTP2 = true
+	if t.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	}
+	if t.Proxy != "" {
+		proxy, err := url.Parse(t.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url: %s", t.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	var rt http.RoundTripper = transport
+	if t.Trace {
+		rt = &tracingTransport{base: transport}
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// redactedHeaders are never logged by tracingTransport
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// tracingTransport logs every request and response made through base
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	log.Printf("> %s %s %s", req.Method, req.URL, formatHeaders(req.Header))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		log.Printf("< %s %s failed after %s: %s", req.Method, req.URL, time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	log.Printf("< %s %s %s %s in %s %s", req.Method, req.URL, resp.Proto, resp.Status, time.Since(start).Round(time.Millisecond), formatHeaders(resp.Header))
+	return resp, nil
+}
+
+// formatHeaders returns h on one line, sorted, with the values of redactedHeaders replaced
+func formatHeaders(h http.Header) string {
+	var rv []string
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		if matchesAny(redactedHeaders, http.CanonicalHeaderKey(name)) {
+			value = "[REDACTED]"
+		}
+		rv = append(rv, name+": "+value)
+	}
+	sort.Strings(rv)
+	return "{" + strings.Join(rv, "; ") + "}"
+}
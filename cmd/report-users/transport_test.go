@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCACert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		Options transportOptions
+		OK      bool
+	}{
+		{transportOptions{}, false},
+		{transportOptions{CACert: path}, true},
+		{transportOptions{InsecureSkipVerify: true}, true},
+	} {
+		client, err := tc.Options.httpClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tc.OK {
+			t.Fatalf("%+v: expected success %v, got %v", tc.Options, tc.OK, err)
+		}
+	}
+}
+
+func TestTransportOptionsErrors(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "empty.pem")
+	err := ioutil.WriteFile(notPEM, []byte("nothing here"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []transportOptions{
+		{CACert: notPEM},
+		{CACert: "/does/not/exist.pem"},
+		{ClientCert: notPEM},
+		{ClientCert: notPEM, ClientKey: notPEM},
+	} {
+		_, err := opts.tlsConfig()
+		if err == nil {
+			t.Fatalf("%+v: expected an error", opts)
+		}
+	}
+}
+
+func TestProxyAndTrace(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Set-Cookie", "session=secret")
+	}))
+	defer proxy.Close()
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	client, err := (&transportOptions{Proxy: proxy.URL, Trace: true}).httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://api.example.invalid/v2/info", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "bearer secret-token")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(proxied) != 1 || proxied[0] != "http://api.example.invalid/v2/info" {
+		t.Fatalf("expected the request to go through the proxy, got %v", proxied)
+	}
+	if strings.Contains(logged.String(), "secret") {
+		t.Fatalf("expected credentials to be redacted:\n%s", logged.String())
+	}
+	if !strings.Contains(logged.String(), "> GET http://api.example.invalid/v2/info {Authorization: [REDACTED]}") {
+		t.Fatalf("expected the request to be traced:\n%s", logged.String())
+	}
+
+	_, err = (&transportOptions{Proxy: "proxy.example.com"}).httpClient()
+	if err == nil {
+		t.Fatal("expected a proxy without a scheme to be refused")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uaaConnection implements cfConnection without the cf CLI, by fetching
+// tokens from UAA directly using either client credentials or a refresh token
+type uaaConnection struct {
+	// API url, ie "https://api.system.example.com"
+	API string
+
+	// Token, if set, is used as-is and never refreshed
+	Token string
+
+	// ClientID and ClientSecret are used for the client_credentials grant,
+	// or to authenticate the refresh_token grant if RefreshToken is set
+	ClientID     string
+	ClientSecret string
+
+	// RefreshToken, if set, is exchanged for access tokens
+	RefreshToken string
+
+	// TokenEndpoint is the UAA url, if empty it is discovered from the API
+	TokenEndpoint string
+
+	// SSLDisabled is set if TLS verification should be skipped, ie if
+	// logged in with "cf login --skip-ssl-validation"
+	SSLDisabled bool
+
+	client *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func (u *uaaConnection) ApiEndpoint() (string, error) {
+	if u.API == "" {
+		return "", errors.New("no API endpoint set")
+	}
+	return u.API, nil
+}
+
+func (u *uaaConnection) IsSSLDisabled() (bool, error) {
+	return u.SSLDisabled, nil
+}
+
+// AccessToken returns an Authorization header value, ie "bearer eyXXXXX",
+// fetching a new token if we don't have one that is valid for at least another minute
+func (u *uaaConnection) AccessToken() (string, error) {
+	if u.Token != "" {
+		if strings.Contains(u.Token, " ") {
+			return u.Token, nil
+		}
+		return "bearer " + u.Token, nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.accessToken != "" && time.Now().Add(time.Minute).Before(u.expiry) {
+		return u.accessToken, nil
+	}
+
+	err := u.fetchToken()
+	if err != nil {
+		return "", err
+	}
+	return u.accessToken, nil
+}
+
+func (u *uaaConnection) httpClient() *http.Client {
+	if u.client == nil {
+		return http.DefaultClient
+	}
+	return u.client
+}
+
+// discoverTokenEndpoint looks up the UAA url from the API's /v2/info
+func (u *uaaConnection) discoverTokenEndpoint() error {
+	if u.TokenEndpoint != "" {
+		return nil
+	}
+	resp, err := u.httpClient().Get(u.API + "/v2/info")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code fetching %s/v2/info: %d", u.API, resp.StatusCode)
+	}
+
+	var info struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return err
+	}
+	if info.TokenEndpoint == "" {
+		return errors.New("no token_endpoint in /v2/info")
+	}
+	u.TokenEndpoint = info.TokenEndpoint
+	return nil
+}
+
+// fetchToken makes a token request to UAA, must be called with mu held
+func (u *uaaConnection) fetchToken() error {
+	err := u.discoverTokenEndpoint()
+	if err != nil {
+		return err
+	}
+
+	clientID := u.ClientID
+	form := url.Values{}
+	switch {
+	case u.RefreshToken != "":
+		if clientID == "" {
+			clientID = "cf" // same default client as the cf CLI
+		}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", u.RefreshToken)
+	case u.ClientID != "":
+		form.Set("grant_type", "client_credentials")
+	default:
+		return errors.New("no token, client credentials or refresh token set")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(u.TokenEndpoint, "/")+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(u.ClientSecret))
+
+	resp, err := u.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code fetching token: %d", resp.StatusCode)
+	}
+
+	var tok struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tok)
+	if err != nil {
+		return err
+	}
+	if tok.AccessToken == "" {
+		return errors.New("no access_token in UAA response")
+	}
+
+	u.accessToken = "bearer " + tok.AccessToken
+	u.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	if tok.RefreshToken != "" && u.RefreshToken != "" {
+		// UAA may rotate refresh tokens
+		u.RefreshToken = tok.RefreshToken
+	}
+	return nil
+}
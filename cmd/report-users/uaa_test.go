@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUAAConnectionClientCredentials(t *testing.T) {
+	tokenRequests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/info":
+			json.NewEncoder(w).Encode(map[string]string{"token_endpoint": server.URL + "/uaa"})
+		case "/uaa/oauth/token":
+			id, secret, _ := r.BasicAuth()
+			if r.FormValue("grant_type") != "client_credentials" || id != "reporter" || secret != "s3cret" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			tokenRequests++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": "abc",
+				"token_type":   "bearer",
+				"expires_in":   3600,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	conn := &uaaConnection{
+		API:          server.URL,
+		ClientID:     "reporter",
+		ClientSecret: "s3cret",
+	}
+	for i := 0; i < 2; i++ {
+		at, err := conn.AccessToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if at != "bearer abc" {
+			t.Fatalf("unexpected token: %s", at)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Fatalf("expected token to be cached, got %d token requests", tokenRequests)
+	}
+
+	conn.ClientSecret = "wrong"
+	conn.accessToken = ""
+	if _, err := conn.AccessToken(); err == nil {
+		t.Fatal("expected error with bad credentials")
+	}
+}
+
+func TestUAAConnectionStaticToken(t *testing.T) {
+	at, err := (&uaaConnection{Token: "xyz"}).AccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at != "bearer xyz" {
+		t.Fatalf("unexpected token: %s", at)
+	}
+}
+
+func TestCFConfigConnection(t *testing.T) {
+	// header.{"exp":4102444800}.signature
+	token := "bearer eyJhbGciOiJSUzI1NiJ9.eyJleHAiOjQxMDI0NDQ4MDB9.c2ln"
+	conn := (&cfConfig{
+		Target:       "https://api.example.com",
+		AccessToken:  token,
+		RefreshToken: "refresh",
+		UaaEndpoint:  "https://uaa.example.com",
+	}).connection()
+
+	if conn.ClientID != "cf" {
+		t.Fatalf("expected default cf client, got %q", conn.ClientID)
+	}
+	if conn.expiry.Unix() != 4102444800 {
+		t.Fatalf("unexpected expiry: %v", conn.expiry)
+	}
+
+	// token is still valid, so no request should be made to UAA
+	at, err := conn.AccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at != token {
+		t.Fatalf("unexpected token: %s", at)
+	}
+}
+
+func TestCFConfigFallback(t *testing.T) {
+	config := &cfConfig{
+		Target:       "https://api.example.com",
+		AccessToken:  "bearer saved",
+		RefreshToken: "refresh",
+		UaaEndpoint:  "https://uaa.example.com",
+		SSLDisabled:  true,
+	}
+
+	conn := config.fallback(&uaaConnection{})
+	if conn.RefreshToken != "refresh" {
+		t.Fatalf("expected the saved login to be used, got %+v", conn)
+	}
+
+	conn = config.fallback(&uaaConnection{ClientID: "report-users", ClientSecret: "secret"})
+	if conn.API != "https://api.example.com" || conn.TokenEndpoint != "https://uaa.example.com" || !conn.SSLDisabled {
+		t.Fatalf("expected the saved target, got %+v", conn)
+	}
+	if conn.ClientID != "report-users" || conn.ClientSecret != "secret" || conn.RefreshToken != "" || conn.accessToken != "" {
+		t.Fatalf("expected the client credentials given to win, got %+v", conn)
+	}
+
+	conn = config.fallback(&uaaConnection{Token: "given"})
+	at, err := conn.AccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at != "bearer given" {
+		t.Fatalf("expected the token given to win, got %s", at)
+	}
+}
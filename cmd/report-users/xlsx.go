@@ -0,0 +1,267 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// xlsxSheet is one worksheet. The first row is treated as a header, and
+// cells may be strings or ints.
+type xlsxSheet struct {
+	Name string
+	Rows [][]interface{}
+}
+
+// roleOrder is the order roles are listed in summaries
+var roleOrder = []string{
+	"OrgUser",
+	"OrgManager",
+	"OrgBillingManager",
+	"OrgAuditor",
+	"SpaceManager",
+	"SpaceDeveloper",
+	"SpaceAuditor",
+}
+
+// writeXLSXReport writes a workbook with a summary sheet, then one sheet per
+// org. If the report is incomplete the summary has an Incomplete column, and
+// an Incomplete sheet after it lists errs.
+func writeXLSXReport(out io.Writer, items []*userInfoLineItem, errs []*crawlError) error {
+	orgs := groupByOrg(items)
+
+	incomplete := len(errs) != 0
+	for _, item := range items {
+		incomplete = incomplete || item.Incomplete
+	}
+	header := []interface{}{"Organization", "Users", "Role assignments"}
+	for _, role := range roleOrder {
+		header = append(header, role)
+	}
+	if incomplete {
+		header = append(header, "Incomplete")
+	}
+	summary := &xlsxSheet{Name: "Summary", Rows: [][]interface{}{header}}
+	sheets := []*xlsxSheet{summary}
+	if len(errs) != 0 {
+		failed := &xlsxSheet{
+			Name: "Incomplete",
+			Rows: [][]interface{}{{"Where", "URL", "Error"}},
+		}
+		for _, e := range errs {
+			failed.Rows = append(failed.Rows, []interface{}{e.where(), e.URL, e.Error})
+		}
+		sheets = append(sheets, failed)
+	}
+	for _, org := range orgs {
+		users := make(map[string]bool)
+		roles := make(map[string]int)
+		orgIncomplete := "no"
+		sheet := &xlsxSheet{
+			Name: org.Name,
+			Rows: [][]interface{}{{"Username", "Space", "Role"}},
+		}
+		for _, item := range org.Items {
+			users[item.Username] = true
+			roles[item.Role]++
+			if item.Incomplete {
+				orgIncomplete = "yes"
+			}
+			sheet.Rows = append(sheet.Rows, []interface{}{item.Username, item.Space, item.Role})
+		}
+
+		row := []interface{}{org.Name, len(users), len(org.Items)}
+		for _, role := range roleOrder {
+			row = append(row, roles[role])
+		}
+		if incomplete {
+			row = append(row, orgIncomplete)
+		}
+		summary.Rows = append(summary.Rows, row)
+		sheets = append(sheets, sheet)
+	}
+
+	return writeXLSX(out, sheets)
+}
+
+// xlsxSheetNames returns valid, unique worksheet names for each sheet. Excel
+// limits names to 31 characters, forbids some punctuation, and compares names
+// case-insensitively.
+func xlsxSheetNames(sheets []*xlsxSheet) []string {
+	replacer := strings.NewReplacer("[", "_", "]", "_", ":", "_", "*", "_", "?", "_", "/", "_", `\`, "_")
+	seen := make(map[string]bool)
+	var rv []string
+	for _, sheet := range sheets {
+		base := strings.Trim(replacer.Replace(sheet.Name), "'")
+		if base == "" {
+			base = "Sheet"
+		}
+		name := truncateRunes(base, 31)
+		for n := 2; seen[strings.ToLower(name)]; n++ {
+			suffix := fmt.Sprintf(" (%d)", n)
+			name = truncateRunes(base, 31-len(suffix)) + suffix
+		}
+		seen[strings.ToLower(name)] = true
+		rv = append(rv, name)
+	}
+	return rv
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// xlsxColumn returns the column letters for zero-based column i, ie 0 is "A", 26 is "AA"
+func xlsxColumn(i int) string {
+	var rv string
+	for i++; i > 0; i = (i - 1) / 26 {
+		rv = string(rune('A'+(i-1)%26)) + rv
+	}
+	return rv
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const xlsxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const xlsxStyles = xlsxHeader + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+// writeXLSX writes a minimal Office Open XML workbook. Every sheet has a bold,
+// frozen header row with an autofilter over all of its rows.
+func writeXLSX(out io.Writer, sheets []*xlsxSheet) error {
+	names := xlsxSheetNames(sheets)
+
+	var contentTypes, workbook, workbookRels, definedNames bytes.Buffer
+	contentTypes.WriteString(xlsxHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(xlsxHeader + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	workbookRels.WriteString(xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	files := make(map[string][]byte)
+	for i, sheet := range sheets {
+		n := i + 1
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(names[i]), n, n)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+
+		ref := xlsxFilterRef(sheet)
+		if ref != "" {
+			// Excel expects a hidden name for each sheet's autofilter range
+			absRef := xlsxAbsolute(ref)
+			fmt.Fprintf(&definedNames, `<definedName name="_xlnm._FilterDatabase" localSheetId="%d" hidden="1">%s</definedName>`,
+				i, xmlEscape("'"+strings.Replace(names[i], "'", "''", -1)+"'!"+absRef))
+		}
+		files[fmt.Sprintf("xl/worksheets/sheet%d.xml", n)] = xlsxWorksheet(sheet, ref)
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets>`)
+	if definedNames.Len() > 0 {
+		workbook.WriteString(`<definedNames>` + definedNames.String() + `</definedNames>`)
+	}
+	workbook.WriteString(`</workbook>`)
+	fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(sheets)+1)
+	workbookRels.WriteString(`</Relationships>`)
+
+	files["[Content_Types].xml"] = contentTypes.Bytes()
+	files["_rels/.rels"] = []byte(xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`)
+	files["xl/workbook.xml"] = workbook.Bytes()
+	files["xl/_rels/workbook.xml.rels"] = workbookRels.Bytes()
+	files["xl/styles.xml"] = []byte(xlsxStyles)
+
+	// write in a stable order, with the content types first as some readers expect
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i] == "[Content_Types].xml" || paths[j] == "[Content_Types].xml" {
+			return paths[i] == "[Content_Types].xml"
+		}
+		return paths[i] < paths[j]
+	})
+
+	zw := zip.NewWriter(out)
+	for _, p := range paths {
+		w, err := zw.Create(p)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(files[p])
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// xlsxFilterRef returns the range covering all rows and columns of the sheet, ie "A1:C10"
+func xlsxFilterRef(sheet *xlsxSheet) string {
+	if len(sheet.Rows) == 0 || len(sheet.Rows[0]) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("A1:%s%d", xlsxColumn(len(sheet.Rows[0])-1), len(sheet.Rows))
+}
+
+// xlsxAbsolute converts a range like "A1:C10" to "$A$1:$C$10"
+func xlsxAbsolute(ref string) string {
+	var parts []string
+	for _, cell := range strings.Split(ref, ":") {
+		i := strings.IndexAny(cell, "0123456789")
+		parts = append(parts, "$"+cell[:i]+"$"+cell[i:])
+	}
+	return strings.Join(parts, ":")
+}
+
+func xlsxWorksheet(sheet *xlsxSheet, filterRef string) []byte {
+	var b bytes.Buffer
+	b.WriteString(xlsxHeader + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	b.WriteString(`<sheetData>`)
+	for r, row := range sheet.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+		style := ""
+		if r == 0 {
+			style = ` s="1"`
+		}
+		for c, cell := range row {
+			ref := fmt.Sprintf("%s%d", xlsxColumn(c), r+1)
+			switch v := cell.(type) {
+			case int:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%d</v></c>`, ref, style, v)
+			default:
+				fmt.Fprintf(&b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlEscape(fmt.Sprint(v)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData>`)
+	if filterRef != "" {
+		fmt.Fprintf(&b, `<autoFilter ref="%s"/>`, filterRef)
+	}
+	b.WriteString(`</worksheet>`)
+	return b.Bytes()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXLSXSheetNames(t *testing.T) {
+	got := xlsxSheetNames([]*xlsxSheet{
+		{Name: "Summary"},
+		{Name: "summary"},
+		{Name: "a/b:c"},
+		{Name: "an-organization-name-that-is-far-too-long"},
+		{Name: "an-organization-name-that-is-far-too-long-2"},
+	})
+	expected := []string{
+		"Summary",
+		"summary (2)",
+		"a_b_c",
+		"an-organization-name-that-is-fa",
+		"an-organization-name-that-i (2)",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected names: %q", got)
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for i, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != expected {
+			t.Fatalf("column %d: expected %s, got %s", i, expected, got)
+		}
+	}
+}
+
+func TestWriteXLSXReport(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeXLSXReport(&buf, []*userInfoLineItem{
+		{Organization: "org-one", Space: "dev", Username: "alice", Role: "SpaceDeveloper"},
+		{Organization: "org-two", Username: "bob & carol", Role: "OrgManager"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, contents := readXLSX(t, buf.Bytes())
+	if zr.File[0].Name != "[Content_Types].xml" {
+		t.Fatalf("expected content types first, got %s", zr.File[0].Name)
+	}
+	for _, s := range []string{`name="Summary"`, `name="org-one"`, `name="org-two"`} {
+		if !strings.Contains(contents["xl/workbook.xml"], s) {
+			t.Fatalf("expected workbook to contain %s:\n%s", s, contents["xl/workbook.xml"])
+		}
+	}
+	if !strings.Contains(contents["xl/worksheets/sheet3.xml"], `<autoFilter ref="A1:C2"/>`) {
+		t.Fatalf("expected autofilter:\n%s", contents["xl/worksheets/sheet3.xml"])
+	}
+	if !strings.Contains(contents["xl/worksheets/sheet3.xml"], "bob &amp; carol") {
+		t.Fatalf("expected escaped username:\n%s", contents["xl/worksheets/sheet3.xml"])
+	}
+}
+
+// readXLSX returns the workbook parts by name, checking every part is well-formed XML
+func readXLSX(t *testing.T, b []byte) (*zip.Reader, map[string]string) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every part must be well-formed XML
+		d := xml.NewDecoder(bytes.NewReader(b))
+		for {
+			_, err := d.Token()
+			if err != nil {
+				if err != io.EOF {
+					t.Fatalf("%s: %s", f.Name, err)
+				}
+				break
+			}
+		}
+		contents[f.Name] = string(b)
+	}
+	return zr, contents
+}
+
+func TestWriteXLSXReportIncomplete(t *testing.T) {
+	items := []*userInfoLineItem{
+		{Organization: "org-one", Username: "alice", Role: "OrgManager"},
+		{Organization: "org-two", Username: "bob", Role: "OrgManager"},
+	}
+	var buf bytes.Buffer
+	err := writeXLSXReport(&buf, items, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, contents := readXLSX(t, buf.Bytes())
+	if strings.Contains(contents["xl/worksheets/sheet1.xml"], "Incomplete") {
+		t.Fatalf("expected no incomplete column in a complete report:\n%s", contents["xl/worksheets/sheet1.xml"])
+	}
+
+	items[1].Incomplete = true
+	buf.Reset()
+	err = writeXLSXReport(&buf, items, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, contents = readXLSX(t, buf.Bytes())
+	summary := contents["xl/worksheets/sheet1.xml"]
+	for _, s := range []string{">Incomplete</t>", `<c r="K2" t="inlineStr"><is><t xml:space="preserve">no</t>`, `<c r="K3" t="inlineStr"><is><t xml:space="preserve">yes</t>`} {
+		if !strings.Contains(summary, s) {
+			t.Fatalf("expected summary to contain %s:\n%s", s, summary)
+		}
+	}
+
+	// orgs that failed before any roles were found are listed on their own sheet
+	items[1].Incomplete = false
+	buf.Reset()
+	err = writeXLSXReport(&buf, items, []*crawlError{{Organization: "org-three", URL: "/v2/organizations/org-3/spaces", Error: "bad status code: 404"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, contents = readXLSX(t, buf.Bytes())
+	if !strings.Contains(contents["xl/workbook.xml"], `name="Incomplete"`) || !strings.Contains(contents["xl/worksheets/sheet2.xml"], "/v2/organizations/org-3/spaces") {
+		t.Fatalf("expected an Incomplete sheet listing the error:\n%s", contents["xl/worksheets/sheet2.xml"])
+	}
+	if !strings.Contains(contents["xl/worksheets/sheet1.xml"], ">Incomplete</t>") {
+		t.Fatalf("expected an incomplete column on the summary:\n%s", contents["xl/worksheets/sheet1.xml"])
+	}
+}